@@ -26,6 +26,18 @@ table "instance_info" {
     null = false
   }
 
+  column "schema_version" {
+    type    = text
+    null    = false
+    default = ""
+  }
+
+  column "detector" {
+    type    = text
+    null    = false
+    default = ""
+  }
+
   column "cached_at" {
     type    = timestamptz
     null    = false
@@ -40,3 +52,31 @@ table "instance_info" {
     columns = [column.cached_at]
   }
 }
+
+table "instance_metadata" {
+  schema = schema.public
+
+  column "domain" {
+    type = text
+    null = false
+  }
+
+  column "data" {
+    type = text
+    null = false
+  }
+
+  column "cached_at" {
+    type    = timestamptz
+    null    = false
+    default = sql("NOW()")
+  }
+
+  primary_key {
+    columns = [column.domain]
+  }
+
+  index "idx_instance_metadata_cached_at" {
+    columns = [column.cached_at]
+  }
+}
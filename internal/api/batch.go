@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	// maxBatchSize is the most domains a single batch request may ask about.
+	maxBatchSize = 100
+
+	// batchWorkers bounds how many cache misses a single batch request resolves
+	// concurrently.
+	batchWorkers = 8
+
+	// maxBatchBodySize caps the size of a batch request body.
+	maxBatchBodySize = 64 << 10
+)
+
+type batchRequest struct {
+	Instances []string `json:"instances"`
+}
+
+// batchResult is the per-domain result of a batch lookup. Either the embedded
+// softwareResponse or Error is set.
+type batchResult struct {
+	*softwareResponse
+	Domain string `json:"domain,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Results map[string]*batchResult `json:"results"`
+}
+
+// SoftwareBatchHandler returns software info for many fediverse instances at once.
+//
+// Each instance is looked up exactly as SoftwareHandler would: cache hits are
+// answered directly, and misses are detected concurrently by a bounded pool of
+// workers. Results are keyed by the instance string as it was sent. Each miss
+// spends a token from the client's fetch budget, and misses over the limit
+// come back as per-instance errors.
+//
+// Request Body:
+//
+//	{"instances": ["mastodon.social", "pixelfed.social", "not a domain"]}
+//
+// Response (200 OK):
+//
+//	{
+//	  "results": {
+//	    "mastodon.social": {"software": "mastodon", "version": "4.2.0", "detector": "nodeinfo", "cached": true, "domain": "mastodon.social"},
+//	    "pixelfed.social": {"software": "pixelfed", "version": "0.12.3", "detector": "nodeinfo", "cached": false, "domain": "pixelfed.social"},
+//	    "not a domain": {"error": "invalid domain: idna: disallowed rune U+0020"}
+//	  }
+//	}
+//
+// Errors:
+//   - 400 Bad Request: Malformed body, no instances, or more than 100 instances
+//   - 405 Method Not Allowed: Non-POST request
+//   - 429 Too Many Requests: Client is over its lookup rate limit (see Retry-After)
+func SoftwareBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Instances) == 0 {
+		http.Error(w, "Missing instances", http.StatusBadRequest)
+		return
+	}
+	if len(req.Instances) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("Too many instances (max %d)", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(batchResponse{
+		Results: lookupSoftwareBatch(r.Context(), req.Instances),
+	})
+}
+
+// lookupSoftwareBatch resolves the software for each of the given instances.
+//
+// Inputs that normalise to the same domain are only looked up once.
+func lookupSoftwareBatch(ctx context.Context, instances []string) map[string]*batchResult {
+	results := make(map[string]*batchResult, len(instances))
+
+	// domain -> the inputs that normalised to it
+	pending := map[string][]string{}
+	for _, instance := range instances {
+		if _, ok := results[instance]; ok {
+			continue
+		}
+		domain, err := normalizeInstance(instance)
+		if err != nil {
+			results[instance] = &batchResult{Error: err.Error()}
+			continue
+		}
+		results[instance] = nil
+		pending[domain] = append(pending[domain], instance)
+	}
+
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for range min(batchWorkers, len(pending)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for domain := range jobs {
+				result := &batchResult{Domain: domain}
+				if resp, err := lookupSoftware(ctx, domain); err != nil {
+					result.Error = err.Error()
+				} else {
+					result.softwareResponse = resp
+				}
+
+				mu.Lock()
+				for _, instance := range pending[domain] {
+					results[instance] = result
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for domain := range pending {
+		jobs <- domain
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
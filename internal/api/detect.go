@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Detector names, reported back to clients and stored alongside cached info so
+// we can tell how a given instance was identified.
+const (
+	detectorNodeInfo      = "nodeinfo"
+	detectorMastodonV2    = "mastodon-api-v2"
+	detectorMastodonV1    = "mastodon-api-v1"
+	detectorMisskey       = "misskey-api"
+	detectorLemmy         = "lemmy-api"
+	detectorHTMLGenerator = "html-generator"
+)
+
+// maxDetectBodySize caps how much of a probe response we're willing to read.
+// Instance APIs are small, and the generator tag lives in the <head>.
+const maxDetectBodySize = 512 << 10
+
+var errNotDetected = errors.New("software not detected")
+
+// detection is the outcome of running the detection pipeline against an instance.
+type detection struct {
+	Software      string
+	Version       string
+	SchemaVersion string // only set when Detector is detectorNodeInfo
+	Detector      string
+}
+
+type detector struct {
+	name   string
+	detect func(ctx context.Context, instance string) (software, version string, err error)
+}
+
+// fallbackDetectors are tried in order when an instance has no usable nodeinfo.
+var fallbackDetectors = []detector{
+	{detectorMastodonV2, detectMastodonV2},
+	{detectorMastodonV1, detectMastodonV1},
+	{detectorMisskey, detectMisskey},
+	{detectorLemmy, detectLemmy},
+	{detectorHTMLGenerator, detectHTMLGenerator},
+}
+
+// detectSoftware works out what software an instance is running.
+//
+// Nodeinfo is always tried first. If the instance answered but without usable
+// nodeinfo, each fallback detector is probed in turn and the first one to
+// identify the software wins. If it didn't answer at all (DNS, connection, TLS,
+// timeout or backoff), there's no point probing it five more times, so the
+// nodeinfo error is returned straight away.
+//
+// Returns:
+//   - *detection: The detected software, version and the detector that matched
+//   - error: The nodeinfo error if no detector matched
+func detectSoftware(ctx context.Context, instance string) (*detection, error) {
+	ctx, span := tracer.Start(ctx, "detectSoftware")
+	defer span.End()
+	span.SetAttributes(attribute.String("webap.domain", instance))
+
+	ni, nodeInfoErr := fetchNodeInfo(ctx, instance)
+	if nodeInfoErr == nil && ni.softwareName() != "" {
+		span.SetAttributes(attribute.String("webap.detector", detectorNodeInfo))
+		return &detection{
+			Software:      ni.softwareName(),
+			Version:       ni.Software.Version,
+			SchemaVersion: ni.Version,
+			Detector:      detectorNodeInfo,
+		}, nil
+	}
+	if nodeInfoErr == nil {
+		nodeInfoErr = errors.New("nodeinfo has no software name")
+	}
+
+	lastErr := nodeInfoErr
+	for _, d := range fallbackDetectors {
+		if !hostAnswered(ctx, lastErr) {
+			break
+		}
+		software, version, err := d.detect(ctx, instance)
+		if err != nil || software == "" {
+			slog.DebugContext(ctx, "detector did not match",
+				"domain", instance, "detector", d.name, "error", err)
+			if err != nil {
+				lastErr = err
+			}
+			continue
+		}
+		span.SetAttributes(attribute.String("webap.detector", d.name))
+		return &detection{
+			Software: strings.ToLower(software),
+			Version:  version,
+			Detector: d.name,
+		}, nil
+	}
+
+	span.SetStatus(codes.Error, errNotDetected.Error())
+	slog.WarnContext(ctx, "software detection failed",
+		"domain", instance, "error_class", errorClass(nodeInfoErr), "error", nodeInfoErr)
+	if lastErr != nodeInfoErr && !hostAnswered(ctx, lastErr) {
+		// A probe gave up on the host part way through (e.g. it asked us to
+		// back off), which callers need to see.
+		return nil, fmt.Errorf("%w (nodeinfo: %w; %w)", errNotDetected, nodeInfoErr, lastErr)
+	}
+	return nil, fmt.Errorf("%w (nodeinfo: %w)", errNotDetected, nodeInfoErr)
+}
+
+// hostAnswered reports whether err came from an instance that answered, with
+// an unexpected status or a response we couldn't use, rather than one we
+// couldn't reach (or have been told to leave alone).
+func hostAnswered(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch errorClass(err) {
+	case "http_status", "invalid_response":
+		return !errors.Is(err, context.Canceled)
+	}
+	return false
+}
+
+// probe performs a request against an instance and returns the (size limited)
+// body of a 200 response.
+func probe(ctx context.Context, method, instance, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "https://"+instance+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{path, resp.StatusCode}
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxDetectBodySize))
+}
+
+func probeJSON(ctx context.Context, method, instance, path string, body []byte, v any) error {
+	data, err := probe(ctx, method, instance, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// compatibleVersion matches the version string Mastodon API compatible servers
+// report, e.g. "2.7.2 (compatible; Pleroma 2.5.0)".
+var compatibleVersion = regexp.MustCompile(`\(compatible; ([^ )]+) ([^ )]+)\)`)
+
+// parseMastodonVersion splits the version reported by the Mastodon instance API
+// into software and version, recognising forks and compatible implementations.
+func parseMastodonVersion(raw string) (software, version string) {
+	if m := compatibleVersion.FindStringSubmatch(raw); m != nil {
+		return m[1], m[2]
+	}
+
+	version, suffix, _ := strings.Cut(raw, "+")
+	switch {
+	case strings.HasPrefix(suffix, "glitch"):
+		return "glitch-soc", version
+	case strings.HasPrefix(suffix, "hometown"):
+		return "hometown", version
+	}
+	return "mastodon", version
+}
+
+func detectMastodonV2(ctx context.Context, instance string) (software, version string, err error) {
+	var resp struct {
+		Domain  string `json:"domain"`
+		Version string `json:"version"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, instance, "/api/v2/instance", nil, &resp); err != nil {
+		return "", "", err
+	}
+	if resp.Version == "" {
+		return "", "", errNotDetected
+	}
+	software, version = parseMastodonVersion(resp.Version)
+	return software, version, nil
+}
+
+func detectMastodonV1(ctx context.Context, instance string) (software, version string, err error) {
+	var resp struct {
+		URI     string `json:"uri"`
+		Version string `json:"version"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, instance, "/api/v1/instance", nil, &resp); err != nil {
+		return "", "", err
+	}
+	if resp.Version == "" {
+		return "", "", errNotDetected
+	}
+	software, version = parseMastodonVersion(resp.Version)
+	return software, version, nil
+}
+
+func detectMisskey(ctx context.Context, instance string) (software, version string, err error) {
+	var resp struct {
+		Version string `json:"version"`
+		URI     string `json:"uri"`
+	}
+	// Misskey's API is POST only, even for read-only endpoints.
+	if err := probeJSON(ctx, http.MethodPost, instance, "/api/meta", []byte("{}"), &resp); err != nil {
+		return "", "", err
+	}
+	if resp.Version == "" {
+		return "", "", errNotDetected
+	}
+	return "misskey", resp.Version, nil
+}
+
+func detectLemmy(ctx context.Context, instance string) (software, version string, err error) {
+	var resp struct {
+		Version  string          `json:"version"`
+		SiteView json.RawMessage `json:"site_view"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, instance, "/api/v3/site", nil, &resp); err != nil {
+		return "", "", err
+	}
+	if resp.Version == "" || resp.SiteView == nil {
+		return "", "", errNotDetected
+	}
+	return "lemmy", resp.Version, nil
+}
+
+var (
+	metaTagPattern         = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaAttrPattern        = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	trailingVersionPattern = regexp.MustCompile(`^(.*?)\s+v?(\d[\w.\-+]*)$`)
+)
+
+// fediverseGenerators maps the lowercased start of a generator tag to the
+// software it names, for fediverse software that sets one. Anything else (Hugo,
+// a WordPress blog without nodeinfo, ...) isn't an instance we can redirect to.
+var fediverseGenerators = []struct{ prefix, software string }{
+	{"writefreely", "writefreely"},
+	{"plume", "plume"},
+	{"friendica", "friendica"},
+	{"hubzilla", "hubzilla"},
+	{"gnu social", "gnusocial"},
+	{"pleroma", "pleroma"},
+	{"akkoma", "akkoma"},
+	{"gotosocial", "gotosocial"},
+	{"pixelfed", "pixelfed"},
+	{"peertube", "peertube"},
+	{"funkwhale", "funkwhale"},
+	{"mobilizon", "mobilizon"},
+	{"bookwyrm", "bookwyrm"},
+	{"misskey", "misskey"},
+	{"sharkey", "sharkey"},
+	{"mastodon", "mastodon"},
+}
+
+// parseGenerator recognises a generator tag's content (e.g., "WriteFreely
+// 0.15.0") as fediverse software.
+//
+// Returns:
+//   - string: The software name, lowercased (e.g., "writefreely")
+//   - string: The version, if the tag has one
+//   - bool: Whether it's fediverse software we know
+func parseGenerator(content string) (software, version string, ok bool) {
+	name := strings.TrimSpace(content)
+	if m := trailingVersionPattern.FindStringSubmatch(name); m != nil {
+		name, version = m[1], m[2]
+	}
+	lower := strings.ToLower(name)
+	for _, g := range fediverseGenerators {
+		rest, found := strings.CutPrefix(lower, g.prefix)
+		if found && (rest == "" || !isWordChar(rest[0])) {
+			return g.software, version, true
+		}
+	}
+	return "", "", false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z'
+}
+
+// detectHTMLGenerator looks for a <meta name="generator"> tag naming known
+// fediverse software on the instance's front page, e.g.
+// `<meta name="generator" content="WriteFreely 0.15.0">`.
+func detectHTMLGenerator(ctx context.Context, instance string) (software, version string, err error) {
+	body, err := probe(ctx, http.MethodGet, instance, "/", nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, tag := range metaTagPattern.FindAll(body, -1) {
+		attrs := map[string]string{}
+		for _, m := range metaAttrPattern.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(string(m[2]) + string(m[3]))
+		}
+		if !strings.EqualFold(attrs["name"], "generator") {
+			continue
+		}
+
+		if software, version, ok := parseGenerator(attrs["content"]); ok {
+			return software, version, nil
+		}
+	}
+
+	return "", "", errNotDetected
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParseGenerator(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantSW      string
+		wantVersion string
+		wantOK      bool
+	}{
+		{name: "writefreely", input: "WriteFreely 0.15.0", wantSW: "writefreely", wantVersion: "0.15.0", wantOK: true},
+		{name: "no version", input: "WriteFreely", wantSW: "writefreely", wantOK: true},
+		{name: "v prefix", input: "Plume v0.7.2", wantSW: "plume", wantVersion: "0.7.2", wantOK: true},
+		{name: "codename", input: "Friendica 'Giant Rhubarb' 2023.05", wantSW: "friendica", wantVersion: "2023.05", wantOK: true},
+		{name: "two words", input: "GNU social 2.0.1", wantSW: "gnusocial", wantVersion: "2.0.1", wantOK: true},
+		{name: "whitespace", input: "  Hubzilla 8.4 ", wantSW: "hubzilla", wantVersion: "8.4", wantOK: true},
+
+		{name: "wordpress", input: "WordPress 6.4.2"},
+		{name: "hugo", input: "Hugo 0.120.4"},
+		{name: "jekyll", input: "Jekyll v4.3.2"},
+		{name: "prefix of another word", input: "Plumery 1.0"},
+		{name: "empty", input: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw, version, ok := parseGenerator(tt.input)
+			if sw != tt.wantSW || version != tt.wantVersion || ok != tt.wantOK {
+				t.Errorf("parseGenerator(%q) = %q, %q, %v, want %q, %q, %v",
+					tt.input, sw, version, ok, tt.wantSW, tt.wantVersion, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHostAnswered(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "not found", err: &statusError{"nodeinfo", 404}, want: true},
+		{name: "bad json", err: errors.New("invalid character '<'"), want: true},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "nope.example"}},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+		{name: "timeout", err: fmt.Errorf("fetch: %w", context.DeadlineExceeded)},
+		{name: "backoff", err: &backoffError{host: "busy.example"}},
+		{name: "canceled error", err: fmt.Errorf("fetch: %w", context.Canceled)},
+		{name: "canceled context", ctx: canceled, err: &statusError{"nodeinfo", 404}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if got := hostAnswered(ctx, tt.err); got != tt.want {
+				t.Errorf("hostAnswered(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
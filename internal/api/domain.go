@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Domain validation errors
+var (
+	errEmptyDomain   = errors.New("empty domain")
+	errIPDomain      = errors.New("IP addresses are not supported")
+	errSingleLabel   = errors.New("domain must have at least two labels")
+	errNumericTLD    = errors.New("top-level domain cannot be numeric")
+	errInvalidDomain = errors.New("invalid domain")
+)
+
+// domainProfile converts domains to their ASCII (punycode) form using the
+// lookup rules, while also enforcing DNS length limits and hostname characters.
+var domainProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.VerifyDNSLength(true),
+	idna.StrictDomainName(true),
+)
+
+// normalizeInstance turns user supplied instance input into a canonical,
+// lowercase ASCII hostname suitable for use as a cache key and in outbound URLs.
+//
+// Accepts bare domains as well as URLs, and strips surrounding whitespace, the
+// scheme, userinfo, port, path, query, fragment and any trailing dot. Unicode
+// (IDN) domains are converted to punycode, e.g. "Takahē.Social" becomes
+// "xn--takah-mza.social".
+//
+// Parameters:
+//   - instance: The raw instance input (e.g., "https://Mastodon.Social/@user")
+//
+// Returns:
+//   - string: The normalised hostname (e.g., "mastodon.social")
+//   - error: If the input isn't a valid public hostname
+func normalizeInstance(instance string) (string, error) {
+	host := strings.TrimSpace(instance)
+
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	if i := strings.IndexAny(host, "/?#"); i != -1 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+
+	if strings.HasPrefix(host, "[") {
+		return "", errIPDomain
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	if host == "" {
+		return "", errEmptyDomain
+	}
+	if net.ParseIP(host) != nil {
+		return "", errIPDomain
+	}
+
+	ascii, err := domainProfile.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errInvalidDomain, err)
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", errSingleLabel
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", errNumericTLD
+	}
+
+	return ascii, nil
+}
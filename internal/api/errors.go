@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+)
+
+// statusError is returned when a remote instance answers with an unexpected
+// HTTP status.
+type statusError struct {
+	what string
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.what, e.code)
+}
+
+// errorClass buckets an outbound fetch error into a small, fixed set of
+// classes, suitable for metric labels and logs.
+//
+// Returns "ok" for a nil error.
+func errorClass(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	var (
+		statusErr  *statusError
+		backoffErr *backoffError
+		dnsErr     *net.DNSError
+		certErr    *tls.CertificateVerificationError
+		hostErr    x509.HostnameError
+		recordErr  tls.RecordHeaderError
+		opErr      *net.OpError
+		urlErr     *url.Error
+	)
+
+	switch {
+	case errors.As(err, &backoffErr):
+		return "backoff"
+	case errors.As(err, &statusErr):
+		return "http_status"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &hostErr), errors.As(err, &recordErr):
+		return "tls"
+	case errors.As(err, &opErr):
+		return "connection"
+	case errors.As(err, &urlErr):
+		return "transport"
+	default:
+		return "invalid_response"
+	}
+}
@@ -0,0 +1,479 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"webap.to/internal/cache"
+)
+
+// misskeyFamily lists software that speaks the Misskey API rather than the
+// Mastodon one.
+var misskeyFamily = []string{
+	"misskey", "sharkey", "firefish", "iceshrimp", "catodon", "foundkey", "calckey", "cherrypick",
+}
+
+type instanceThumbnail struct {
+	URL string `json:"url"`
+}
+
+type instanceRegistrations struct {
+	Enabled          bool   `json:"enabled"`
+	ApprovalRequired bool   `json:"approval_required"`
+	Message          string `json:"message,omitempty"`
+}
+
+type instanceRule struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Hint string `json:"hint,omitempty"`
+}
+
+// instanceMetadata is the normalised instance metadata document. It follows the
+// shape of Mastodon's /api/v2/instance, plus a software block and the list of
+// sources it was assembled from.
+type instanceMetadata struct {
+	Domain      string `json:"domain"`
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	SourceURL   string `json:"source_url,omitempty"`
+	Description string `json:"description"`
+	Usage       struct {
+		Users struct {
+			ActiveMonth int `json:"active_month"`
+		} `json:"users"`
+	} `json:"usage"`
+	Thumbnail     *instanceThumbnail    `json:"thumbnail"`
+	Languages     []string              `json:"languages"`
+	Registrations instanceRegistrations `json:"registrations"`
+	Rules         []instanceRule        `json:"rules"`
+	Software      struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"software"`
+	Sources []string `json:"sources"`
+	Cached  bool     `json:"cached"`
+}
+
+type instanceSource struct {
+	name  string
+	fetch func(ctx context.Context, domain string, meta *instanceMetadata) error
+}
+
+var (
+	mastodonV2Source = instanceSource{detectorMastodonV2, fetchMastodonV2Metadata}
+	mastodonV1Source = instanceSource{detectorMastodonV1, fetchMastodonV1Metadata}
+	misskeySource    = instanceSource{detectorMisskey, fetchMisskeyMetadata}
+	lemmySource      = instanceSource{detectorLemmy, fetchLemmyMetadata}
+)
+
+// instanceSourcesFor returns the native instance APIs worth trying for the
+// given software, in order of preference. An empty software name (nodeinfo
+// unavailable) tries all of them.
+func instanceSourcesFor(software string) []instanceSource {
+	switch {
+	case software == "":
+		return []instanceSource{mastodonV2Source, mastodonV1Source, misskeySource, lemmySource}
+	case slices.Contains(misskeyFamily, software):
+		return []instanceSource{misskeySource}
+	case software == "lemmy":
+		return []instanceSource{lemmySource}
+	default:
+		return []instanceSource{mastodonV2Source, mastodonV1Source}
+	}
+}
+
+// InstanceHandler returns normalised metadata for a fediverse instance.
+//
+// Aggregates the instance's nodeinfo with its software-native instance API
+// (Mastodon, Misskey or Lemmy) into a single document shaped like Mastodon's
+// /api/v2/instance. Results are cached for 24 hours.
+//
+// Query Parameters:
+//   - domain: The domain of the fediverse instance (e.g., "mastodon.social")
+//
+// Response (200 OK):
+//
+//	{
+//	  "domain": "mastodon.social",
+//	  "title": "Mastodon",
+//	  "version": "4.2.0",
+//	  "description": "The original server operated by the Mastodon gGmbH non-profit",
+//	  "usage": {"users": {"active_month": 250000}},
+//	  "thumbnail": {"url": "https://files.mastodon.social/site_uploads/files/000/000/001/@1x/57c12f441d083cde.png"},
+//	  "languages": ["en"],
+//	  "registrations": {"enabled": true, "approval_required": false},
+//	  "rules": [{"id": "1", "text": "Sexually explicit or violent media must be marked as sensitive"}],
+//	  "software": {"name": "mastodon", "version": "4.2.0"},
+//	  "sources": ["nodeinfo", "mastodon-api-v2"],
+//	  "cached": false
+//	}
+//
+// Errors:
+//   - 400 Bad Request: Missing or invalid domain parameter
+//   - 405 Method Not Allowed: Non-GET request
+//   - 429 Too Many Requests: Client is over its lookup or fetch rate limit (see Retry-After)
+//   - 502 Bad Gateway: Neither nodeinfo nor a native instance API responded
+//   - 503 Service Unavailable: The instance asked us to back off (see Retry-After)
+func InstanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		http.Error(w, "Missing domain parameter", http.StatusBadRequest)
+		return
+	}
+	domain, err := normalizeInstance(domain)
+	if err != nil {
+		http.Error(w, "Invalid domain parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := lookupInstanceMetadata(r.Context(), domain)
+	if writeFetchError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch instance metadata: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(meta)
+}
+
+// lookupInstanceMetadata returns the metadata for a normalised instance
+// domain, from the cache if it's there, otherwise fetched and cached. Fetching
+// spends one of the client's outbound fetches, so a client over its limit gets
+// a *ratelimit.Error instead, and an instance we're backing off from gets a
+// *backoffError.
+func lookupInstanceMetadata(ctx context.Context, domain string) (*instanceMetadata, error) {
+	if meta, ok := cachedInstanceMetadata(ctx, domain); ok {
+		return meta, nil
+	}
+
+	if err := allowFetch(ctx, domain); err != nil {
+		return nil, err
+	}
+	meta, err := fetchInstanceMetadata(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if instanceCache != nil {
+		if data, err := json.Marshal(meta); err == nil {
+			_ = instanceCache.SetMetadata(ctx, &cache.InstanceMetadata{
+				Domain:   domain,
+				Data:     data,
+				CachedAt: time.Now(),
+			})
+		}
+	}
+
+	return meta, nil
+}
+
+// cachedInstanceMetadata returns the cached metadata for a normalised
+// instance domain, without fetching anything.
+func cachedInstanceMetadata(ctx context.Context, domain string) (*instanceMetadata, bool) {
+	if instanceCache == nil {
+		return nil, false
+	}
+	cached, err := instanceCache.GetMetadata(ctx, domain)
+	if err != nil || cached == nil {
+		return nil, false
+	}
+	var meta instanceMetadata
+	if err := json.Unmarshal(cached.Data, &meta); err != nil {
+		return nil, false
+	}
+	meta.Cached = true
+	return &meta, true
+}
+
+// fetchInstanceMetadata builds the normalised metadata document for an instance.
+//
+// Nodeinfo provides the baseline, then the first native instance API that
+// responds fills in (and takes precedence for) the richer fields.
+func fetchInstanceMetadata(ctx context.Context, domain string) (*instanceMetadata, error) {
+	meta := &instanceMetadata{
+		Domain:    domain,
+		Languages: []string{},
+		Rules:     []instanceRule{},
+		Sources:   []string{},
+	}
+
+	var software string
+	ni, nodeInfoErr := fetchNodeInfo(ctx, domain)
+	if nodeInfoErr == nil {
+		software = ni.softwareName()
+		meta.applyNodeInfo(ni)
+		meta.Sources = append(meta.Sources, detectorNodeInfo)
+	}
+
+	for _, src := range instanceSourcesFor(software) {
+		err := src.fetch(ctx, domain, meta)
+		if err == nil {
+			meta.Sources = append(meta.Sources, src.name)
+			break
+		}
+		slog.DebugContext(ctx, "instance source failed",
+			"domain", domain, "source", src.name, "error", err)
+	}
+
+	if len(meta.Sources) == 0 {
+		slog.WarnContext(ctx, "instance metadata unavailable",
+			"domain", domain, "error_class", errorClass(nodeInfoErr), "error", nodeInfoErr)
+		return nil, fmt.Errorf("no instance metadata available (nodeinfo: %w)", nodeInfoErr)
+	}
+
+	if meta.Title == "" {
+		meta.Title = domain
+	}
+
+	return meta, nil
+}
+
+func (m *instanceMetadata) applyNodeInfo(ni *nodeInfo) {
+	m.Software.Name = ni.softwareName()
+	m.Software.Version = ni.Software.Version
+	m.Version = ni.Software.Version
+	m.SourceURL = ni.Software.Repository
+	m.Usage.Users.ActiveMonth = ni.Usage.Users.ActiveMonth
+	m.Registrations.Enabled = ni.OpenRegistrations
+
+	m.Title = ni.Instance.Name
+	m.Description = ni.Instance.Description
+
+	// Pre-2.2 servers commonly put the instance name and description in the
+	// free-form metadata block instead.
+	if m.Title == "" || m.Description == "" {
+		var md struct {
+			NodeName        string `json:"nodeName"`
+			NodeDescription string `json:"nodeDescription"`
+		}
+		if len(ni.Metadata) > 0 && json.Unmarshal(ni.Metadata, &md) == nil {
+			if m.Title == "" {
+				m.Title = md.NodeName
+			}
+			if m.Description == "" {
+				m.Description = md.NodeDescription
+			}
+		}
+	}
+}
+
+// setIfNotEmpty overwrites dst with src, unless src is empty.
+func setIfNotEmpty(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+func fetchMastodonV2Metadata(ctx context.Context, domain string, meta *instanceMetadata) error {
+	var resp struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		SourceURL   string `json:"source_url"`
+		Description string `json:"description"`
+		Usage       struct {
+			Users struct {
+				ActiveMonth int `json:"active_month"`
+			} `json:"users"`
+		} `json:"usage"`
+		Thumbnail struct {
+			URL string `json:"url"`
+		} `json:"thumbnail"`
+		Languages     []string              `json:"languages"`
+		Registrations instanceRegistrations `json:"registrations"`
+		Rules         []instanceRule        `json:"rules"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, domain, "/api/v2/instance", nil, &resp); err != nil {
+		return err
+	}
+	if resp.Version == "" {
+		return errNotDetected
+	}
+
+	setIfNotEmpty(&meta.Title, resp.Title)
+	setIfNotEmpty(&meta.Description, resp.Description)
+	setIfNotEmpty(&meta.SourceURL, resp.SourceURL)
+	meta.Version = resp.Version
+	if resp.Usage.Users.ActiveMonth > 0 {
+		meta.Usage.Users.ActiveMonth = resp.Usage.Users.ActiveMonth
+	}
+	if resp.Thumbnail.URL != "" {
+		meta.Thumbnail = &instanceThumbnail{URL: resp.Thumbnail.URL}
+	}
+	if resp.Languages != nil {
+		meta.Languages = resp.Languages
+	}
+	meta.Registrations = resp.Registrations
+	if resp.Rules != nil {
+		meta.Rules = resp.Rules
+	}
+	if meta.Software.Name == "" {
+		meta.Software.Name, meta.Software.Version = parseMastodonVersion(resp.Version)
+	}
+
+	return nil
+}
+
+func fetchMastodonV1Metadata(ctx context.Context, domain string, meta *instanceMetadata) error {
+	var resp struct {
+		Title            string         `json:"title"`
+		Version          string         `json:"version"`
+		ShortDescription string         `json:"short_description"`
+		Description      string         `json:"description"`
+		Thumbnail        string         `json:"thumbnail"`
+		Languages        []string       `json:"languages"`
+		Registrations    bool           `json:"registrations"`
+		ApprovalRequired bool           `json:"approval_required"`
+		Rules            []instanceRule `json:"rules"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, domain, "/api/v1/instance", nil, &resp); err != nil {
+		return err
+	}
+	if resp.Version == "" {
+		return errNotDetected
+	}
+
+	setIfNotEmpty(&meta.Title, resp.Title)
+	setIfNotEmpty(&meta.Description, resp.Description)
+	setIfNotEmpty(&meta.Description, resp.ShortDescription)
+	meta.Version = resp.Version
+	if resp.Thumbnail != "" {
+		meta.Thumbnail = &instanceThumbnail{URL: resp.Thumbnail}
+	}
+	if resp.Languages != nil {
+		meta.Languages = resp.Languages
+	}
+	meta.Registrations = instanceRegistrations{
+		Enabled:          resp.Registrations,
+		ApprovalRequired: resp.ApprovalRequired,
+	}
+	if resp.Rules != nil {
+		meta.Rules = resp.Rules
+	}
+	if meta.Software.Name == "" {
+		meta.Software.Name, meta.Software.Version = parseMastodonVersion(resp.Version)
+	}
+
+	return nil
+}
+
+func fetchMisskeyMetadata(ctx context.Context, domain string, meta *instanceMetadata) error {
+	var resp struct {
+		Name                string   `json:"name"`
+		Version             string   `json:"version"`
+		Description         string   `json:"description"`
+		BannerURL           string   `json:"bannerUrl"`
+		Langs               []string `json:"langs"`
+		DisableRegistration bool     `json:"disableRegistration"`
+		ServerRules         []string `json:"serverRules"`
+		RepositoryURL       string   `json:"repositoryUrl"`
+	}
+	if err := probeJSON(ctx, http.MethodPost, domain, "/api/meta", []byte("{}"), &resp); err != nil {
+		return err
+	}
+	if resp.Version == "" {
+		return errNotDetected
+	}
+
+	setIfNotEmpty(&meta.Title, resp.Name)
+	setIfNotEmpty(&meta.Description, resp.Description)
+	setIfNotEmpty(&meta.SourceURL, resp.RepositoryURL)
+	meta.Version = resp.Version
+	if resp.BannerURL != "" {
+		meta.Thumbnail = &instanceThumbnail{URL: resp.BannerURL}
+	}
+	if resp.Langs != nil {
+		meta.Languages = resp.Langs
+	}
+	meta.Registrations.Enabled = !resp.DisableRegistration
+	if resp.ServerRules != nil {
+		meta.Rules = make([]instanceRule, len(resp.ServerRules))
+		for i, text := range resp.ServerRules {
+			meta.Rules[i] = instanceRule{ID: strconv.Itoa(i + 1), Text: text}
+		}
+	}
+	if meta.Software.Name == "" {
+		meta.Software.Name, meta.Software.Version = "misskey", resp.Version
+	}
+
+	return nil
+}
+
+func fetchLemmyMetadata(ctx context.Context, domain string, meta *instanceMetadata) error {
+	var resp struct {
+		Version  string `json:"version"`
+		SiteView *struct {
+			Site struct {
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Sidebar     string `json:"sidebar"`
+				Icon        string `json:"icon"`
+				Banner      string `json:"banner"`
+			} `json:"site"`
+			LocalSite struct {
+				RegistrationMode    string `json:"registration_mode"`
+				ApplicationQuestion string `json:"application_question"`
+			} `json:"local_site"`
+			Counts struct {
+				UsersActiveMonth int `json:"users_active_month"`
+			} `json:"counts"`
+		} `json:"site_view"`
+	}
+	if err := probeJSON(ctx, http.MethodGet, domain, "/api/v3/site", nil, &resp); err != nil {
+		return err
+	}
+	if resp.Version == "" || resp.SiteView == nil {
+		return errNotDetected
+	}
+
+	site := resp.SiteView.Site
+	local := resp.SiteView.LocalSite
+
+	setIfNotEmpty(&meta.Title, site.Name)
+	setIfNotEmpty(&meta.Description, site.Sidebar)
+	setIfNotEmpty(&meta.Description, site.Description)
+	meta.Version = resp.Version
+	if resp.SiteView.Counts.UsersActiveMonth > 0 {
+		meta.Usage.Users.ActiveMonth = resp.SiteView.Counts.UsersActiveMonth
+	}
+	switch {
+	case site.Banner != "":
+		meta.Thumbnail = &instanceThumbnail{URL: site.Banner}
+	case site.Icon != "":
+		meta.Thumbnail = &instanceThumbnail{URL: site.Icon}
+	}
+	switch local.RegistrationMode {
+	case "Open":
+		meta.Registrations = instanceRegistrations{Enabled: true}
+	case "RequireApplication":
+		meta.Registrations = instanceRegistrations{
+			Enabled:          true,
+			ApprovalRequired: true,
+			Message:          local.ApplicationQuestion,
+		}
+	case "Closed":
+		meta.Registrations = instanceRegistrations{}
+	}
+	if meta.Software.Name == "" {
+		meta.Software.Name, meta.Software.Version = "lemmy", resp.Version
+	}
+
+	return nil
+}
@@ -4,21 +4,34 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
 	"webap.to/internal/cache"
+	"webap.to/internal/metrics"
+	"webap.to/internal/tracing"
 )
 
 var httpClient = &http.Client{
-	Timeout: 10 * time.Second,
+	Timeout:   10 * time.Second,
+	Transport: tracing.Transport(outbound),
 }
 
+var tracer = otel.Tracer("webap.to/internal/api")
+
 var instanceCache cache.Cache
 
 // SetCache sets the cache instance used by API handlers.
@@ -29,43 +42,69 @@ func SetCache(c cache.Cache) {
 	instanceCache = c
 }
 
-func setCORSHeaders(w http.ResponseWriter, origin string) {
-	w.Header().Set("Access-Control-Allow-Origin", origin)
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-}
+// nodeInfoSchemaPrefix is the common part of every nodeinfo schema rel, the
+// version number follows it (e.g. "http://nodeinfo.diaspora.software/ns/schema/2.1").
+const nodeInfoSchemaPrefix = "nodeinfo.diaspora.software/ns/schema/"
 
-func normalizeInstance(instance string) string {
-	instance = strings.TrimPrefix(instance, "https://")
-	instance = strings.TrimPrefix(instance, "http://")
-	instance = strings.Split(instance, "/")[0]
-	return strings.ToLower(instance)
+// nodeInfoSchemaVersions lists the nodeinfo schema versions we understand, most
+// preferred first.
+var nodeInfoSchemaVersions = []string{"2.2", "2.1", "2.0", "1.1", "1.0"}
+
+type nodeInfoLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
 }
 
 type nodeInfoLinks struct {
-	Links []struct {
-		Rel  string `json:"rel"`
-		Href string `json:"href"`
-	} `json:"links"`
+	Links []nodeInfoLink `json:"links"`
 }
 
-type nodeInfoResponse struct {
+// nodeInfo is the subset of a nodeinfo document we care about. Fields that only
+// exist in later schema versions are simply left empty for older documents.
+type nodeInfo struct {
+	// Version is the schema version the document declares (e.g. "2.1").
+	Version  string `json:"version"`
 	Software struct {
-		Name    string `json:"name"`
-		Version string `json:"version"`
+		Name       string `json:"name"`
+		Version    string `json:"version"`
+		Repository string `json:"repository"` // 2.1+
+		Homepage   string `json:"homepage"`   // 2.1+
 	} `json:"software"`
+	OpenRegistrations bool `json:"openRegistrations"`
+	Usage             struct {
+		Users struct {
+			Total          int `json:"total"`
+			ActiveHalfyear int `json:"activeHalfyear"`
+			ActiveMonth    int `json:"activeMonth"`
+			ActiveWeek     int `json:"activeWeek"` // 2.2+
+		} `json:"users"`
+		LocalPosts int `json:"localPosts"`
+	} `json:"usage"`
+	// Instance is only present from 2.2 (FEP-0151) onwards.
+	Instance struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"instance"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+func (ni *nodeInfo) softwareName() string {
+	return strings.ToLower(ni.Software.Name)
 }
 
 type softwareResponse struct {
-	Software string `json:"software"`
-	Version  string `json:"version"`
-	Cached   bool   `json:"cached"`
+	Software      string `json:"software"`
+	Version       string `json:"version"`
+	SchemaVersion string `json:"schema_version,omitempty"`
+	Detector      string `json:"detector,omitempty"`
+	Cached        bool   `json:"cached"`
 }
 
 // SoftwareHandler returns the software name and version for a fediverse instance.
 //
 // Returns cached data if available, otherwise fetches from the instance's
-// well-known nodeinfo endpoint and caches the result.
+// well-known nodeinfo endpoint and caches the result. Instances without nodeinfo
+// are probed through the software-specific APIs instead (see detectSoftware).
 //
 // Query Parameters:
 //   - instance: The domain of the fediverse instance (e.g., "mastodon.social")
@@ -75,25 +114,18 @@ type softwareResponse struct {
 //	{
 //	  "software": "mastodon",
 //	  "version": "4.2.0",
+//	  "schema_version": "2.0",
+//	  "detector": "nodeinfo",
 //	  "cached": true
 //	}
 //
 // Errors:
-//   - 400 Bad Request: Missing instance parameter
-//   - 405 Method Not Allowed: Non-GET/OPTIONS request
-//   - 502 Bad Gateway: Failed to fetch nodeinfo from instance
+//   - 400 Bad Request: Missing or invalid instance parameter
+//   - 405 Method Not Allowed: Non-GET request
+//   - 429 Too Many Requests: Client is over its lookup or fetch rate limit (see Retry-After)
+//   - 502 Bad Gateway: Failed to detect the instance's software
+//   - 503 Service Unavailable: The instance asked us to back off (see Retry-After)
 func SoftwareHandler(w http.ResponseWriter, r *http.Request) {
-	origin := "*"
-	if v, ok := r.Header["Origin"]; ok && len(v) > 0 {
-		origin = v[0]
-	}
-	setCORSHeaders(w, origin)
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -104,93 +136,236 @@ func SoftwareHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing instance parameter", http.StatusBadRequest)
 		return
 	}
-	instance = normalizeInstance(instance)
+	instance, err := normalizeInstance(instance)
+	if err != nil {
+		http.Error(w, "Invalid instance parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := lookupSoftware(r.Context(), instance)
+	if writeFetchError(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to detect software: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
 
+// lookupSoftware returns the software info for a normalised instance domain.
+//
+// Cached info is returned when available, otherwise the software is detected
+// and the result cached. Detection spends a token from the client's fetch
+// budget, so a client over its limit gets a *ratelimit.Error instead.
+func lookupSoftware(ctx context.Context, instance string) (*softwareResponse, error) {
 	if instanceCache != nil {
-		if info, err := instanceCache.Get(instance); err == nil && info != nil {
-			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(softwareResponse{
-				Software: info.Software,
-				Version:  info.Version,
-				Cached:   true,
-			})
-			return
+		if info, err := instanceCache.Get(ctx, instance); err == nil && info != nil {
+			if info.Expired() {
+				metrics.SoftwareLookups.WithLabelValues("stale").Inc()
+			} else {
+				metrics.SoftwareLookups.WithLabelValues("hit").Inc()
+			}
+			return &softwareResponse{
+				Software:      info.Software,
+				Version:       info.Version,
+				SchemaVersion: info.SchemaVersion,
+				Detector:      info.Detector,
+				Cached:        true,
+			}, nil
 		}
 	}
 
-	software, version, err := fetchNodeInfo(instance)
+	metrics.SoftwareLookups.WithLabelValues("miss").Inc()
+
+	if err := allowFetch(ctx, instance); err != nil {
+		return nil, err
+	}
+
+	detected, err := detectSoftware(ctx, instance)
 	if err != nil {
-		http.Error(w, "Failed to fetch nodeinfo: "+err.Error(), http.StatusBadGateway)
-		return
+		return nil, err
 	}
 
 	if instanceCache != nil {
-		_ = instanceCache.Set(&cache.InstanceInfo{
-			Domain:   instance,
-			Software: software,
-			Version:  version,
-			CachedAt: time.Now(),
+		_ = instanceCache.Set(ctx, &cache.InstanceInfo{
+			Domain:        instance,
+			Software:      detected.Software,
+			Version:       detected.Version,
+			SchemaVersion: detected.SchemaVersion,
+			Detector:      detected.Detector,
+			CachedAt:      time.Now(),
 		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(softwareResponse{
-		Software: software,
-		Version:  version,
-		Cached:   false,
-	})
+	return &softwareResponse{
+		Software:      detected.Software,
+		Version:       detected.Version,
+		SchemaVersion: detected.SchemaVersion,
+		Detector:      detected.Detector,
+		Cached:        false,
+	}, nil
 }
 
-func fetchNodeInfo(instance string) (software, version string, err error) {
-	wellKnownURL := "https://" + instance + "/.well-known/nodeinfo"
-	resp, err := httpClient.Get(wellKnownURL)
+// CachedSoftware returns the cached software name of the instance hosting
+// target, or "unknown" if it isn't cached. It never makes outbound requests.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//   - target: A web+ap target, e.g. "pixelfed.social/p/abc" or "@user@host"
+//
+// Returns:
+//   - string: The software name (e.g., "pixelfed") or "unknown"
+func CachedSoftware(ctx context.Context, target string) string {
+	if instanceCache == nil {
+		return "unknown"
+	}
+	instance, err := normalizeInstance(target)
 	if err != nil {
-		return "", "", err
+		return "unknown"
+	}
+	info, err := instanceCache.Get(ctx, instance)
+	if err != nil || info == nil || info.Software == "" {
+		return "unknown"
+	}
+	return info.Software
+}
+
+// fetchNodeInfo discovers and fetches the nodeinfo document for an instance.
+//
+// The well-known document is searched for the highest schema version we
+// understand (2.2 > 2.1 > 2.0 > 1.x), falling back to any nodeinfo-looking link.
+// Relative hrefs are resolved against the well-known URL. The returned
+// document's Version is always set to the schema version that was used.
+func fetchNodeInfo(ctx context.Context, instance string) (*nodeInfo, error) {
+	ctx, span := tracer.Start(ctx, "fetchNodeInfo")
+	defer span.End()
+	span.SetAttributes(attribute.String("webap.domain", instance))
+
+	start := time.Now()
+	ni, err := requestNodeInfo(ctx, instance)
+	elapsed := time.Since(start)
+
+	outcome := errorClass(err)
+	metrics.NodeInfoFetches.WithLabelValues(outcome).Inc()
+	metrics.NodeInfoDuration.WithLabelValues(outcome).Observe(elapsed.Seconds())
+
+	span.SetAttributes(attribute.String("webap.outcome", outcome))
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, outcome)
+		slog.InfoContext(ctx, "nodeinfo fetch failed",
+			"domain", instance, "error_class", outcome, "duration", elapsed, "error", err)
+	} else {
+		slog.DebugContext(ctx, "nodeinfo fetched",
+			"domain", instance, "software", ni.softwareName(), "schema_version", ni.Version, "duration", elapsed)
+	}
+
+	return ni, err
+}
+
+func requestNodeInfo(ctx context.Context, instance string) (*nodeInfo, error) {
+	wellKnownURL := &url.URL{Scheme: "https", Host: instance, Path: "/.well-known/nodeinfo"}
+	resp, err := httpGet(ctx, wellKnownURL.String())
+	if err != nil {
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("well-known returned status %d", resp.StatusCode)
+		return nil, &statusError{"well-known", resp.StatusCode}
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
 	var links nodeInfoLinks
 	if err := json.Unmarshal(body, &links); err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	var nodeInfoURL, fallbackURL string
-	for _, link := range links.Links {
-		if strings.Contains(link.Rel, "nodeinfo.diaspora.software/ns/schema/2") {
-			nodeInfoURL = link.Href
-			break
-		}
-		if fallbackURL == "" && strings.Contains(link.Rel, "nodeinfo") {
-			fallbackURL = link.Href
-		}
-	}
-	if nodeInfoURL == "" {
-		nodeInfoURL = fallbackURL
+	link, schemaVersion := selectNodeInfoLink(links.Links)
+	if link == nil {
+		return nil, errors.New("no nodeinfo link found")
 	}
 
-	if nodeInfoURL == "" {
-		return "", "", errors.New("no nodeinfo link found")
+	href, err := url.Parse(link.Href)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nodeinfo href: %w", err)
+	}
+	nodeInfoURL := wellKnownURL.ResolveReference(href)
+	if nodeInfoURL.Scheme != "https" && nodeInfoURL.Scheme != "http" {
+		return nil, fmt.Errorf("unsupported nodeinfo href scheme %q", nodeInfoURL.Scheme)
 	}
 
-	nodeInfoResp, err := httpClient.Get(nodeInfoURL)
+	nodeInfoResp, err := httpGet(ctx, nodeInfoURL.String())
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	defer func() { _ = nodeInfoResp.Body.Close() }()
 
-	var nodeInfo nodeInfoResponse
-	if err := json.NewDecoder(nodeInfoResp.Body).Decode(&nodeInfo); err != nil {
-		return "", "", err
+	if nodeInfoResp.StatusCode != http.StatusOK {
+		return nil, &statusError{"nodeinfo", nodeInfoResp.StatusCode}
+	}
+
+	var ni nodeInfo
+	if err := json.NewDecoder(nodeInfoResp.Body).Decode(&ni); err != nil {
+		return nil, err
 	}
 
-	return strings.ToLower(nodeInfo.Software.Name), nodeInfo.Software.Version, nil
+	// The link rel is authoritative for which schema we asked for, the document
+	// itself is the only hint we get when the rel was non-standard.
+	if schemaVersion != "" {
+		ni.Version = schemaVersion
+	}
+
+	return &ni, nil
+}
+
+// httpGet is httpClient.Get, bound to ctx.
+func httpGet(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
+
+// selectNodeInfoLink picks the best nodeinfo link from a well-known document.
+//
+// Returns the link and its schema version, or an empty version if only a
+// non-standard nodeinfo rel was found. Returns nil if there is no usable link.
+func selectNodeInfoLink(links []nodeInfoLink) (link *nodeInfoLink, schemaVersion string) {
+	best := len(nodeInfoSchemaVersions)
+	var fallback *nodeInfoLink
+
+	for i := range links {
+		l := &links[i]
+		if l.Href == "" {
+			continue
+		}
+
+		if idx := strings.Index(l.Rel, nodeInfoSchemaPrefix); idx != -1 {
+			version := strings.TrimSuffix(l.Rel[idx+len(nodeInfoSchemaPrefix):], "/")
+			if rank := slices.Index(nodeInfoSchemaVersions, version); rank != -1 && rank < best {
+				best = rank
+				link = l
+				continue
+			}
+		}
+
+		if fallback == nil && strings.Contains(l.Rel, "nodeinfo") {
+			fallback = l
+		}
+	}
+
+	if link != nil {
+		return link, nodeInfoSchemaVersions[best]
+	}
+	return fallback, ""
 }
@@ -0,0 +1,373 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"webap.to/internal/metrics"
+	"webap.to/internal/ratelimit"
+)
+
+const (
+	// defaultHostRequestsPerMinute and defaultHostConcurrency are the outbound
+	// limits per remote host until SetOutboundLimits says otherwise.
+	defaultHostRequestsPerMinute = 30
+	defaultHostConcurrency       = 2
+
+	// maxOutboundWait is the longest a request will queue for a host's rate
+	// limit before giving up, rather than holding the client's request open.
+	maxOutboundWait = 5 * time.Second
+
+	// defaultBackoff is how long we leave a host alone after a 429 that didn't
+	// say how long to wait, and maxBackoff caps what a host can ask for.
+	defaultBackoff = time.Minute
+	maxBackoff     = time.Hour
+
+	// hostIdleTimeout is how long an idle host's limiter is kept around.
+	hostIdleTimeout = 10 * time.Minute
+
+	// maxTrackedHosts and maxBackoffHosts cap how many hosts have a limiter
+	// or a backoff in memory, so a flood of lookups for distinct hosts can't
+	// grow either without bound.
+	maxTrackedHosts = 10000
+	maxBackoffHosts = 10000
+
+	// sweepInterval is how often expired entries are swept from both.
+	sweepInterval = time.Minute
+)
+
+// backoffError is returned instead of making a request to a host that has
+// told us to back off, or that we're already hitting as hard as we're willing to.
+type backoffError struct {
+	host  string
+	until time.Time
+}
+
+func (e *backoffError) Error() string {
+	return fmt.Sprintf("backing off from %s for %s", e.host, e.RetryAfter().Round(time.Second))
+}
+
+// RetryAfter returns how long is left on the backoff.
+func (e *backoffError) RetryAfter() time.Duration {
+	return max(time.Until(e.until), 0)
+}
+
+// retryAfterSeconds returns RetryAfter rounded up to whole seconds, as used
+// by the Retry-After header.
+func (e *backoffError) retryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter().Seconds())))
+}
+
+// negativeCache remembers hosts we shouldn't contact until a given time, e.g.
+// because they answered 429 with a Retry-After.
+//
+// It deliberately lives in memory rather than in the cache store. It's
+// checked before every outbound request, so a store round trip there would
+// slow down every fetch to spare the odd one; backoffs last an hour at most;
+// and the per-host rate limits it sits beside are per process anyway. The
+// cost of losing it on restart, or of each replica keeping its own, is one
+// more request to the host, which answers 429 again and puts it straight
+// back.
+type negativeCache struct {
+	mu        sync.Mutex
+	hosts     map[string]time.Time
+	lastSweep time.Time
+}
+
+// backoff records that host shouldn't be contacted until until. An existing
+// longer backoff is kept. Expired backoffs are swept out now and then, and
+// once maxBackoffHosts are held the one due to expire soonest makes room.
+func (c *negativeCache) backoff(host string, until time.Time) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hosts == nil {
+		c.hosts = map[string]time.Time{}
+	}
+	if now.Sub(c.lastSweep) > sweepInterval {
+		for h, u := range c.hosts {
+			if now.After(u) {
+				delete(c.hosts, h)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	current, ok := c.hosts[host]
+	if ok && !until.After(current) {
+		return
+	}
+	if !ok && len(c.hosts) >= maxBackoffHosts {
+		soonest := ""
+		for h, u := range c.hosts {
+			if soonest == "" || u.Before(c.hosts[soonest]) {
+				soonest = h
+			}
+		}
+		if !until.After(c.hosts[soonest]) {
+			return
+		}
+		delete(c.hosts, soonest)
+	}
+	c.hosts[host] = until
+}
+
+// check returns a *backoffError if host is currently being backed off from.
+func (c *negativeCache) check(host string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until, ok := c.hosts[host]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(until) {
+		delete(c.hosts, host)
+		return nil
+	}
+	return &backoffError{host, until}
+}
+
+// allowFetch checks that a lookup may go and fetch from host: that host hasn't
+// told us to back off, and that the client has a fetch left. The backoff is
+// checked first so a client isn't charged for a fetch we'd refuse anyway.
+//
+// Returns:
+//   - error: A *backoffError or *ratelimit.Error if not, else nil
+func allowFetch(ctx context.Context, host string) error {
+	if err := outbound.negative.check(host); err != nil {
+		return err
+	}
+	return ratelimit.AllowFetch(ctx)
+}
+
+// writeFetchError writes the response for an error from allowFetch, or from
+// the fetch that followed it, if it's one that has its own status: 429 for a
+// client over its limit, or 503 with a Retry-After for a host we're backing
+// off from.
+//
+// Returns:
+//   - bool: Whether a response was written; if not, the caller should write
+//     its own
+func writeFetchError(w http.ResponseWriter, err error) bool {
+	var limitErr *ratelimit.Error
+	if errors.As(err, &limitErr) {
+		ratelimit.WriteError(w, limitErr)
+		return true
+	}
+	var backoffErr *backoffError
+	if errors.As(err, &backoffErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(backoffErr.retryAfterSeconds()))
+		http.Error(w, "Instance is rate limiting us: "+err.Error(), http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
+
+// hostLimit is the rate limiter and concurrency semaphore for one remote host.
+type hostLimit struct {
+	limiter  *rate.Limiter
+	slots    chan struct{}
+	lastUsed time.Time
+}
+
+// politeTransport caps how often and how concurrently we hit any one remote
+// host, however many clients and domains are asking, and honours the
+// host's 429s.
+type politeTransport struct {
+	next http.RoundTripper
+
+	mu          sync.Mutex
+	perMinute   int
+	concurrency int
+	hosts       map[string]*hostLimit
+	lastSweep   time.Time
+
+	negative negativeCache
+}
+
+// outbound wraps every request httpClient makes.
+var outbound = newPoliteTransport(metrics.InstrumentTransport(http.DefaultTransport))
+
+// SetOutboundLimits sets how hard we're willing to hit any one remote host.
+//
+// Parameters:
+//   - perMinute: Requests per minute per host, 0 for unlimited
+//   - concurrency: Requests in flight at once per host, 0 for unlimited
+func SetOutboundLimits(perMinute, concurrency int) {
+	outbound.setLimits(perMinute, concurrency)
+}
+
+func newPoliteTransport(next http.RoundTripper) *politeTransport {
+	return &politeTransport{
+		next:        next,
+		perMinute:   defaultHostRequestsPerMinute,
+		concurrency: defaultHostConcurrency,
+		hosts:       map[string]*hostLimit{},
+	}
+}
+
+// setLimits changes the per-host limits. Hosts already being tracked keep
+// their old limits until they go idle.
+func (t *politeTransport) setLimits(perMinute, concurrency int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perMinute = perMinute
+	t.concurrency = concurrency
+}
+
+// limitFor returns host's limiter, making one if need be. Idle limiters are
+// swept out now and then, and once maxTrackedHosts are held the least
+// recently used one with nothing in flight makes room. If every one is busy
+// the map grows past the cap for now; it's still bounded by the requests in
+// flight.
+func (t *politeTransport) limitFor(host string) *hostLimit {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) > sweepInterval {
+		for h, l := range t.hosts {
+			if now.Sub(l.lastUsed) > hostIdleTimeout && len(l.slots) == 0 {
+				delete(t.hosts, h)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	l, ok := t.hosts[host]
+	if !ok {
+		if len(t.hosts) >= maxTrackedHosts {
+			oldest := ""
+			for h, l := range t.hosts {
+				if len(l.slots) == 0 && (oldest == "" || l.lastUsed.Before(t.hosts[oldest].lastUsed)) {
+					oldest = h
+				}
+			}
+			if oldest != "" {
+				delete(t.hosts, oldest)
+			}
+		}
+		l = &hostLimit{
+			limiter: rate.NewLimiter(rate.Inf, 0),
+			slots:   make(chan struct{}, max(t.concurrency, 1)),
+		}
+		if t.perMinute > 0 {
+			l.limiter = rate.NewLimiter(rate.Limit(float64(t.perMinute)/60), t.perMinute)
+		}
+		if t.concurrency <= 0 {
+			l.slots = nil
+		}
+		t.hosts[host] = l
+	}
+	l.lastUsed = now
+	return l
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+
+	if err := t.negative.check(host); err != nil {
+		return nil, err
+	}
+
+	l := t.limitFor(host)
+
+	r := l.limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		if delay > maxOutboundWait {
+			r.Cancel()
+			return nil, &backoffError{host, time.Now().Add(delay)}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			r.Cancel()
+			return nil, req.Context().Err()
+		}
+	}
+
+	// The slot is held until the body is closed, not just until the headers
+	// arrive, so slow bodies count against the host's concurrency too.
+	release := func() {}
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = sync.OnceFunc(func() { <-l.slots })
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok && resp.StatusCode == http.StatusTooManyRequests {
+			wait, ok = defaultBackoff, true
+		}
+		if ok {
+			t.negative.backoff(host, time.Now().Add(min(wait, maxBackoff)))
+		}
+	}
+
+	return resp, nil
+}
+
+// releasingBody calls release once the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// parseRetryAfter parses a Retry-After header, either delay-seconds or an
+// HTTP date.
+//
+// Returns:
+//   - time.Duration: How long to wait (never negative)
+//   - bool: Whether the header was present and valid
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(min(secs, int64(math.MaxInt64/time.Second))) * time.Second, true
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		return max(when.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNegativeCacheBounded(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		host      string
+		until     time.Time
+		wantHeld  bool
+		wantFirst bool
+	}{
+		{name: "later backoff evicts soonest", host: "new.example", until: now.Add(2 * time.Hour), wantHeld: true},
+		{name: "earlier backoff is dropped", host: "new.example", until: now.Add(time.Second), wantFirst: true},
+		{name: "existing host is extended", host: "host0.example", until: now.Add(2 * time.Hour), wantHeld: true, wantFirst: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c negativeCache
+			c.backoff("host0.example", now.Add(time.Minute))
+			for i := 1; i < maxBackoffHosts; i++ {
+				c.backoff(fmt.Sprintf("host%d.example", i), now.Add(time.Hour))
+			}
+
+			c.backoff(tt.host, tt.until)
+			if len(c.hosts) > maxBackoffHosts {
+				t.Fatalf("negativeCache holds %d hosts, want at most %d", len(c.hosts), maxBackoffHosts)
+			}
+			if held := c.check(tt.host) != nil; held != tt.wantHeld {
+				t.Errorf("check(%q) backing off = %v, want %v", tt.host, held, tt.wantHeld)
+			}
+			if held := c.check("host0.example") != nil; held != tt.wantFirst {
+				t.Errorf("check(%q) backing off = %v, want %v", "host0.example", held, tt.wantFirst)
+			}
+		})
+	}
+}
+
+func TestNegativeCacheSweep(t *testing.T) {
+	var c negativeCache
+	c.backoff("old.example", time.Now().Add(-time.Second))
+	c.lastSweep = time.Time{}
+	c.backoff("new.example", time.Now().Add(time.Minute))
+
+	if _, ok := c.hosts["old.example"]; ok {
+		t.Errorf("expired backoff for old.example wasn't swept")
+	}
+}
+
+func TestLimitForBounded(t *testing.T) {
+	tr := newPoliteTransport(http.DefaultTransport)
+	busy := tr.limitFor("busy.example")
+	busy.slots <- struct{}{}
+	for i := 1; i < maxTrackedHosts; i++ {
+		tr.limitFor(fmt.Sprintf("host%d.example", i))
+	}
+	busy.lastUsed = time.Now().Add(-2 * time.Hour)
+	tr.hosts["host1.example"].lastUsed = time.Now().Add(-time.Hour)
+
+	tr.limitFor("new.example")
+	if len(tr.hosts) > maxTrackedHosts {
+		t.Fatalf("politeTransport tracks %d hosts, want at most %d", len(tr.hosts), maxTrackedHosts)
+	}
+	if _, ok := tr.hosts["busy.example"]; !ok {
+		t.Errorf("busy.example was evicted with a request in flight")
+	}
+	if _, ok := tr.hosts["host1.example"]; ok {
+		t.Errorf("host1.example, the least recently used idle host, wasn't evicted")
+	}
+}
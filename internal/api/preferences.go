@@ -0,0 +1,413 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// preferencesCookie holds the signed preferences, so the server-rendered
+	// redirect page can honour them without seeing localStorage.
+	preferencesCookie = "webap_prefs"
+
+	// preferencesVersion prefixes the cookie value. Bump it when the format
+	// changes; cookies with an unknown version are ignored.
+	preferencesVersion = "v1"
+
+	preferencesMaxAge = 365 * 24 * time.Hour
+
+	// maxPreferencesSize caps /api/preferences and /set-home request bodies.
+	maxPreferencesSize = 4 << 10
+
+	// maxRedirectDelay is the longest countdown we accept, in seconds.
+	maxRedirectDelay = 60
+
+	// DefaultRedirectDelay is the countdown before redirecting, in seconds, as
+	// in storage.js's DEFAULT_CONFIG.
+	DefaultRedirectDelay = 3
+)
+
+// Preference errors
+var (
+	errBadCookie    = errors.New("invalid preferences cookie")
+	errBadSignature = errors.New("invalid preferences signature")
+	errBadDelay     = fmt.Errorf("delay must be 0-%d or \"never\"", maxRedirectDelay)
+	errCrossSite    = errors.New("cross-site requests are not allowed")
+)
+
+var (
+	preferencesMu  sync.RWMutex
+	preferencesKey []byte
+	publicDomain   string
+	secureRequest  func(*http.Request) bool
+)
+
+// SetPreferencesKey sets the key preference cookies are signed with. Cookies
+// signed with a different key are ignored, so it has to stay the same across
+// restarts (and replicas) for preferences to stick.
+func SetPreferencesKey(key []byte) {
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	preferencesKey = key
+}
+
+// SetPublicDomain sets the domain the site is served on (e.g., "webap.to").
+// Requests that change preferences have to come from a page on it, or on the
+// host they were sent to, and targets wrapped in a link to it are unwrapped.
+func SetPublicDomain(domain string) {
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	publicDomain = domain
+}
+
+// SetSecureRequest sets how to tell whether a request reached the browser over
+// HTTPS, which decides whether the preferences cookie is marked Secure. Until
+// it's set only requests we terminated TLS for count, as a client's own
+// X-Forwarded-Proto can't be trusted.
+//
+// Parameters:
+//   - secure: Reports whether r came in over HTTPS, e.g. from the configured
+//     TLS and trusted proxies
+func SetSecureRequest(secure func(r *http.Request) bool) {
+	preferencesMu.Lock()
+	defer preferencesMu.Unlock()
+	secureRequest = secure
+}
+
+// isSecureRequest reports whether r reached the browser over HTTPS, going by
+// SetSecureRequest.
+func isSecureRequest(r *http.Request) bool {
+	preferencesMu.RLock()
+	secure := secureRequest
+	preferencesMu.RUnlock()
+	if secure == nil {
+		return r.TLS != nil
+	}
+	return secure(r)
+}
+
+// currentPublicDomain returns the domain set by SetPublicDomain, or "" if
+// there isn't one.
+func currentPublicDomain() string {
+	preferencesMu.RLock()
+	defer preferencesMu.RUnlock()
+	return publicDomain
+}
+
+// Delay is the countdown before redirecting, in seconds, or DelayNever to wait
+// for a click. It's a number in JSON, or the string "never".
+type Delay int
+
+// DelayNever means never redirect automatically.
+const DelayNever Delay = -1
+
+// MarshalJSON implements json.Marshaler.
+func (d Delay) MarshalJSON() ([]byte, error) {
+	if d == DelayNever {
+		return []byte(`"never"`), nil
+	}
+	return []byte(strconv.Itoa(int(d))), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Delay) UnmarshalJSON(data []byte) error {
+	if string(data) == `"never"` {
+		*d = DelayNever
+		return nil
+	}
+	n, err := strconv.Atoi(string(data))
+	if err != nil || n < 0 || n > maxRedirectDelay {
+		return errBadDelay
+	}
+	*d = Delay(n)
+	return nil
+}
+
+// Preferences are where someone wants to be redirected to, per kind of
+// content. The JSON form matches storage.js's config.
+type Preferences struct {
+	Social    string `json:"social"`
+	Community string `json:"community"`
+	Photo     string `json:"photo"`
+	Video     string `json:"video"`
+	Music     string `json:"music"`
+	Blog      string `json:"blog"`
+	Delay     Delay  `json:"delay"`
+}
+
+// DefaultPreferences returns the preferences of someone who hasn't set any.
+func DefaultPreferences() *Preferences {
+	return &Preferences{Delay: DefaultRedirectDelay}
+}
+
+// instances returns pointers to each category's instance, in the order
+// Home falls back through them.
+func (p *Preferences) instances() []*string {
+	return []*string{&p.Social, &p.Community, &p.Photo, &p.Video, &p.Music, &p.Blog}
+}
+
+// Home returns the main home instance: the first category with one set, as in
+// storage.js's getHomeInstance.
+//
+// Returns:
+//   - string: The home instance hostname, or "" if none is set
+func (p *Preferences) Home() string {
+	for _, instance := range p.instances() {
+		if *instance != "" {
+			return *instance
+		}
+	}
+	return ""
+}
+
+// SetHome uses home for every category, as in storage.js's setHomeInstance.
+func (p *Preferences) SetHome(home string) {
+	for _, instance := range p.instances() {
+		*instance = home
+	}
+}
+
+// normalize validates and normalises every instance.
+func (p *Preferences) normalize() error {
+	for _, instance := range p.instances() {
+		if *instance == "" {
+			continue
+		}
+		host, err := normalizeInstance(*instance)
+		if err != nil {
+			return fmt.Errorf("invalid instance %q: %w", *instance, err)
+		}
+		*instance = host
+	}
+	return nil
+}
+
+// encodePreferences serialises and signs preferences for the cookie, as
+// "{version}.{base64 JSON}.{base64 HMAC-SHA256 of the rest}".
+func encodePreferences(p *Preferences) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	payload := preferencesVersion + "." + base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signPreferences(payload)), nil
+}
+
+// decodePreferences verifies and parses a cookie value from encodePreferences.
+func decodePreferences(value string) (*Preferences, error) {
+	version, rest, ok := strings.Cut(value, ".")
+	if !ok || version != preferencesVersion {
+		return nil, errBadCookie
+	}
+	data, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, errBadCookie
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, signPreferences(version+"."+data)) {
+		return nil, errBadSignature
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return nil, errBadCookie
+	}
+	p := DefaultPreferences()
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, errBadCookie
+	}
+	if err := p.normalize(); err != nil {
+		return nil, errBadCookie
+	}
+	return p, nil
+}
+
+func signPreferences(payload string) []byte {
+	preferencesMu.RLock()
+	defer preferencesMu.RUnlock()
+	mac := hmac.New(sha256.New, preferencesKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// RequestPreferences returns the preferences from the request's cookie.
+//
+// Parameters:
+//   - r: The request
+//
+// Returns:
+//   - *Preferences: The preferences, or the defaults if there's no valid cookie
+//   - bool: Whether there was a valid cookie
+func RequestPreferences(r *http.Request) (*Preferences, bool) {
+	c, err := r.Cookie(preferencesCookie)
+	if err != nil {
+		return DefaultPreferences(), false
+	}
+	p, err := decodePreferences(c.Value)
+	if err != nil {
+		return DefaultPreferences(), false
+	}
+	return p, true
+}
+
+// setPreferencesCookie stores p in the cookie, or clears it if p has no
+// instances at all.
+func setPreferencesCookie(w http.ResponseWriter, r *http.Request, p *Preferences) error {
+	c := &http.Cookie{
+		Name:     preferencesCookie,
+		Path:     "/",
+		Secure:   isSecureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if p.Home() == "" {
+		c.MaxAge = -1
+	} else {
+		value, err := encodePreferences(p)
+		if err != nil {
+			return err
+		}
+		c.Value = value
+		c.MaxAge = int(preferencesMaxAge / time.Second)
+	}
+	http.SetCookie(w, c)
+	return nil
+}
+
+// checkSameSite refuses requests another site made on the visitor's behalf,
+// so other sites can't change someone's preferences.
+//
+// Browsers that don't send Sec-Fetch-Site are checked by Origin instead, which
+// has to be the host the request was sent to or the public domain. Requests
+// with neither header didn't come from a browser page, so they're let through.
+func checkSameSite(r *http.Request) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return nil
+	case "":
+	default:
+		return errCrossSite
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return errCrossSite
+	}
+	domain := currentPublicDomain()
+	if strings.EqualFold(u.Host, r.Host) || (domain != "" && strings.EqualFold(u.Hostname(), domain)) {
+		return nil
+	}
+	return errCrossSite
+}
+
+// PreferencesHandler handles /api/preferences, the server's copy of the
+// preferences storage.js keeps in localStorage.
+//
+// Methods:
+//   - GET: Returns the preferences, or the defaults if none are set
+//   - PUT: Replaces them with the JSON body and returns the result. Setting no
+//     instances at all clears them.
+//
+// Example response:
+//
+//	{"social":"aus.social","community":"aus.social","photo":"pixelfed.social","video":"aus.social","music":"aus.social","blog":"aus.social","delay":3}
+//
+// Error responses:
+//   - 400 Bad Request: Invalid JSON, instance or delay
+//   - 403 Forbidden: Cross-site PUT
+//   - 405 Method Not Allowed: Not GET or PUT
+func PreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var p *Preferences
+	switch r.Method {
+	case http.MethodGet:
+		p, _ = RequestPreferences(r)
+	case http.MethodPut:
+		if err := checkSameSite(r); err != nil {
+			http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		p = DefaultPreferences()
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPreferencesSize))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(p); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := p.normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setPreferencesCookie(w, r, p); err != nil {
+			http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(p)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// SetHomeHandler handles POST /set-home, the no-JavaScript way to pick a home
+// instance from the redirect page.
+//
+// Form fields:
+//   - instance: The home instance (e.g., "aus.social"), used for every category
+//   - target: The web+ap target being visited, to carry on to afterwards
+//
+// Saves the preferences cookie (keeping the delay) and redirects (303) back to
+// the target's redirect page, or the home page without a valid target.
+//
+// Error responses:
+//   - 400 Bad Request: Invalid instance
+//   - 403 Forbidden: Cross-site request
+func SetHomeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := checkSameSite(r); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPreferencesSize)
+	home, err := normalizeInstance(r.PostFormValue("instance"))
+	if err != nil {
+		http.Error(w, "Invalid instance: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, _ := RequestPreferences(r)
+	p.SetHome(home)
+	if err := setPreferencesCookie(w, r, p); err != nil {
+		http.Error(w, "Failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	dest := "/"
+	if target, err := ParseTarget(r.PostFormValue("target")); err == nil {
+		dest = "/authorize_interaction?uri=" + url.QueryEscape(target.URI)
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
@@ -0,0 +1,264 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// signedPreferences builds a cookie value around raw JSON, signed with the
+// current key, as encodePreferences would for data it had produced itself.
+func signedPreferences(version, raw string) string {
+	payload := version + "." + base64.RawURLEncoding.EncodeToString([]byte(raw))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signPreferences(payload))
+}
+
+func TestPreferencesCookie(t *testing.T) {
+	key := []byte(strings.Repeat("k", 32))
+	otherKey := []byte(strings.Repeat("o", 32))
+	SetPreferencesKey(key)
+	t.Cleanup(func() { SetPreferencesKey(nil) })
+
+	prefs := &Preferences{
+		Social:    "aus.social",
+		Community: "aus.social",
+		Photo:     "pixelfed.social",
+		Video:     "aus.social",
+		Music:     "aus.social",
+		Blog:      "aus.social",
+		Delay:     5,
+	}
+	encode := func(t *testing.T, p *Preferences) string {
+		t.Helper()
+		value, err := encodePreferences(p)
+		if err != nil {
+			t.Fatalf("encodePreferences() unexpected error: %v", err)
+		}
+		return value
+	}
+
+	tests := []struct {
+		name    string
+		value   func(t *testing.T) string
+		want    *Preferences
+		wantErr error
+	}{
+		{
+			name:  "round trip",
+			value: func(t *testing.T) string { return encode(t, prefs) },
+			want:  prefs,
+		},
+		{
+			name:  "never",
+			value: func(t *testing.T) string { return encode(t, &Preferences{Social: "aus.social", Delay: DelayNever}) },
+			want:  &Preferences{Social: "aus.social", Delay: DelayNever},
+		},
+		{
+			name:  "missing delay",
+			value: func(*testing.T) string { return signedPreferences(preferencesVersion, `{"social":"aus.social"}`) },
+			want:  &Preferences{Social: "aus.social", Delay: DefaultRedirectDelay},
+		},
+		{
+			name: "instances normalised",
+			value: func(*testing.T) string {
+				return signedPreferences(preferencesVersion, `{"social":"HTTPS://Aus.Social/"}`)
+			},
+			want: &Preferences{Social: "aus.social", Delay: DefaultRedirectDelay},
+		},
+		{
+			name: "tampered payload",
+			value: func(t *testing.T) string {
+				parts := strings.Split(encode(t, prefs), ".")
+				parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"social":"evil.example"}`))
+				return strings.Join(parts, ".")
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "tampered signature",
+			value: func(t *testing.T) string {
+				parts := strings.Split(encode(t, prefs), ".")
+				sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+				sig[0] ^= 1
+				parts[2] = base64.RawURLEncoding.EncodeToString(sig)
+				return strings.Join(parts, ".")
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "truncated signature",
+			value: func(t *testing.T) string {
+				value := encode(t, prefs)
+				return value[:len(value)-4]
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name: "wrong key",
+			value: func(t *testing.T) string {
+				SetPreferencesKey(otherKey)
+				defer SetPreferencesKey(key)
+				return encode(t, prefs)
+			},
+			wantErr: errBadSignature,
+		},
+		{
+			name:    "wrong version",
+			value:   func(*testing.T) string { return signedPreferences("v2", `{"social":"aus.social"}`) },
+			wantErr: errBadCookie,
+		},
+		{
+			name:    "no version",
+			value:   func(*testing.T) string { return "garbage" },
+			wantErr: errBadCookie,
+		},
+		{
+			name:    "no signature",
+			value:   func(*testing.T) string { return preferencesVersion + ".e30" },
+			wantErr: errBadCookie,
+		},
+		{
+			name: "invalid delay",
+			value: func(*testing.T) string {
+				return signedPreferences(preferencesVersion, `{"social":"aus.social","delay":99}`)
+			},
+			wantErr: errBadCookie,
+		},
+		{
+			name: "negative delay",
+			value: func(*testing.T) string {
+				return signedPreferences(preferencesVersion, `{"social":"aus.social","delay":-1}`)
+			},
+			wantErr: errBadCookie,
+		},
+		{
+			name:    "invalid instance",
+			value:   func(*testing.T) string { return signedPreferences(preferencesVersion, `{"social":"localhost"}`) },
+			wantErr: errBadCookie,
+		},
+		{
+			name:    "invalid json",
+			value:   func(*testing.T) string { return signedPreferences(preferencesVersion, `{"social":`) },
+			wantErr: errBadCookie,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := tt.value(t)
+			got, err := decodePreferences(value)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("decodePreferences(%q) error = %v, want %v", value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodePreferences(%q) unexpected error: %v", value, err)
+			}
+			if *got != *tt.want {
+				t.Errorf("decodePreferences(%q) = %+v, want %+v", value, *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSameSite(t *testing.T) {
+	SetPublicDomain("webap.to")
+	t.Cleanup(func() { SetPublicDomain("") })
+
+	tests := []struct {
+		name      string
+		host      string
+		fetchSite string
+		origin    string
+		wantErr   error
+	}{
+		{name: "same origin", host: "webap.to", fetchSite: "same-origin"},
+		{name: "typed in", host: "webap.to", fetchSite: "none"},
+		{name: "same site", host: "webap.to", fetchSite: "same-site", wantErr: errCrossSite},
+		{name: "cross site", host: "webap.to", fetchSite: "cross-site", wantErr: errCrossSite},
+		{name: "cross site with our origin", host: "webap.to", fetchSite: "cross-site", origin: "https://webap.to", wantErr: errCrossSite},
+		{name: "no headers", host: "webap.to"},
+		{name: "origin is host", host: "localhost:9847", origin: "http://localhost:9847"},
+		{name: "origin is domain", host: "10.0.0.5:9847", origin: "https://webap.to"},
+		{name: "origin is domain uppercase", host: "10.0.0.5:9847", origin: "https://WebAP.to"},
+		{name: "origin is other site", host: "webap.to", origin: "https://evil.example", wantErr: errCrossSite},
+		{name: "origin is subdomain", host: "webap.to", origin: "https://evil.webap.to", wantErr: errCrossSite},
+		{name: "origin is other port", host: "localhost:9847", origin: "http://localhost:8080", wantErr: errCrossSite},
+		{name: "null origin", host: "webap.to", origin: "null", wantErr: errCrossSite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://"+tt.host+"/set-home", nil)
+			if tt.fetchSite != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if err := checkSameSite(r); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkSameSite() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreferencesCookieSecure(t *testing.T) {
+	SetPreferencesKey([]byte(strings.Repeat("k", 32)))
+	t.Cleanup(func() {
+		SetPreferencesKey(nil)
+		SetSecureRequest(nil)
+	})
+
+	viaProxy := func(r *http.Request) bool { return r.RemoteAddr == "10.0.0.1:1234" }
+	tests := []struct {
+		name   string
+		secure func(*http.Request) bool
+		https  bool
+		remote string
+		proto  string
+		want   bool
+	}{
+		{name: "plain http", want: false},
+		{name: "tls", https: true, want: true},
+		{name: "forged forwarded proto", proto: "https", want: false},
+		{name: "trusted proxy", secure: viaProxy, remote: "10.0.0.1:1234", want: true},
+		{name: "untrusted forwarded proto", secure: viaProxy, remote: "192.0.2.1:1234", proto: "https", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetSecureRequest(tt.secure)
+			target := "http://webap.to/api/preferences"
+			if tt.https {
+				target = "https://webap.to/api/preferences"
+			}
+			r := httptest.NewRequest("PUT", target, nil)
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			w := httptest.NewRecorder()
+			if err := setPreferencesCookie(w, r, &Preferences{Social: "aus.social", Delay: DefaultRedirectDelay}); err != nil {
+				t.Fatalf("setPreferencesCookie() unexpected error: %v", err)
+			}
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("setPreferencesCookie() set %d cookies, want 1", len(cookies))
+			}
+			if cookies[0].Secure != tt.want {
+				t.Errorf("cookie Secure = %v, want %v", cookies[0].Secure, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,576 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/html"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// previewTTL is how long a preview built from the target's ActivityPub
+	// object is kept.
+	previewTTL = time.Hour
+
+	// previewFallbackTTL is how long a preview without the object (it wasn't
+	// public, or the instance didn't answer) is kept before trying again.
+	previewFallbackTTL = 10 * time.Minute
+
+	// maxPreviews caps the in-memory preview cache.
+	maxPreviews = 4096
+
+	// previewFetchTimeout bounds building a preview, however many requests it
+	// takes.
+	previewFetchTimeout = 8 * time.Second
+
+	// maxPreviewDescription is the longest description we'll hand out, in
+	// runes. Unfurlers cut them shorter anyway.
+	maxPreviewDescription = 300
+
+	// activityPubAccept asks for the ActivityPub representation of a URL.
+	activityPubAccept = `application/activity+json, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+)
+
+var errNotActivityPub = errors.New("not an ActivityPub document")
+
+// Preview is what a link preview (OpenGraph and Twitter card tags) shows for
+// a web+ap target.
+type Preview struct {
+	// Title is the object's name, or who posted it (e.g., "Jane (@jane@pixelfed.social)").
+	Title string `json:"title"`
+	// Description is the object's text, as plain text.
+	Description string `json:"description,omitempty"`
+	// Image is an attached image, the actor's avatar, or the instance's thumbnail.
+	Image    string `json:"image,omitempty"`
+	ImageAlt string `json:"image_alt,omitempty"`
+	// LargeImage is set when Image is the content itself rather than an avatar
+	// or thumbnail, for twitter:card.
+	LargeImage bool `json:"large_image,omitempty"`
+	// Author is the handle of whoever posted it (e.g., "@jane@pixelfed.social").
+	Author string `json:"author,omitempty"`
+	// SiteName is the target instance's name.
+	SiteName string `json:"site_name"`
+	// URL is the human-readable URL of the target.
+	URL string `json:"url"`
+	// Type is the og:type: "article", "profile" or "website".
+	Type string `json:"type"`
+}
+
+type previewEntry struct {
+	preview  *Preview
+	complete bool
+	expires  time.Time
+}
+
+// previewCache keeps previews in memory. Previews are cheap to rebuild and
+// don't need to outlive the process, but a post going round the fediverse
+// gets unfurled by every instance it reaches at once, so they do need
+// keeping for a while.
+type previewCache struct {
+	mu      sync.Mutex
+	entries map[string]previewEntry
+	flight  singleflight.Group
+}
+
+var previews previewCache
+
+func (c *previewCache) get(key string) (previewEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return previewEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return previewEntry{}, false
+	}
+	return entry, true
+}
+
+// set caches p, which is complete if it was built from the target's object.
+func (c *previewCache) set(key string, p *Preview, complete bool) {
+	ttl := previewFallbackTTL
+	if complete {
+		ttl = previewTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]previewEntry{}
+	}
+	if len(c.entries) >= maxPreviews {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries, so drop some at random.
+		for k := range c.entries {
+			if len(c.entries) < maxPreviews {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = previewEntry{p, complete, time.Now().Add(ttl)}
+}
+
+// CachedPreview returns the cached link preview for target, or the basics if
+// it isn't cached. It never makes outbound requests.
+//
+// Parameters:
+//   - target: The web+ap target
+//
+// Returns:
+//   - *Preview: The preview, never nil
+func CachedPreview(target *Target) *Preview {
+	if entry, ok := previews.get(target.URI); ok {
+		return entry.preview
+	}
+	return basicPreview(target)
+}
+
+// LookupPreview returns the link preview for target, fetching it if it isn't
+// cached. It's built from the target's ActivityPub object (author, text,
+// image), falling back to the instance's metadata for objects we can't fetch
+// (e.g., on instances that require signed requests).
+//
+// Fetching the object spends a token from the client's fetch budget, and
+// falling back to the instance's metadata another. Without one, if
+// the instance asked us to back off, or if ctx is done before the fetch is,
+// only the basics are returned. The fetch carries on regardless, so the next
+// request gets the full preview.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//   - target: The web+ap target
+//
+// Returns:
+//   - *Preview: The preview, never nil
+//   - bool: Whether it was built from the target's object, rather than being
+//     the basics or the instance's metadata, which are worth trying again soon
+func LookupPreview(ctx context.Context, target *Target) (*Preview, bool) {
+	if entry, ok := previews.get(target.URI); ok {
+		return entry.preview, entry.complete
+	}
+	if allowFetch(ctx, target.Host) != nil {
+		return basicPreview(target), false
+	}
+
+	// Concurrent requests for the same target share one fetch. It's detached
+	// from any one request's cancellation, since others may be waiting on it.
+	result := previews.flight.DoChan(target.URI, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), previewFetchTimeout)
+		defer cancel()
+
+		p, err := fetchPreview(ctx, target)
+		if err != nil {
+			slog.DebugContext(ctx, "couldn't fetch target object for preview",
+				"target", target.URI, "error_class", errorClass(err), "error", err)
+		}
+		previews.set(target.URI, p, err == nil)
+		return previewEntry{preview: p, complete: err == nil}, nil
+	})
+
+	select {
+	case res := <-result:
+		entry := res.Val.(previewEntry)
+		return entry.preview, entry.complete
+	case <-ctx.Done():
+		return basicPreview(target), false
+	}
+}
+
+// basicPreview is the preview for a target we know nothing about.
+func basicPreview(target *Target) *Preview {
+	p := &Preview{
+		Title:    target.URI,
+		SiteName: target.Host,
+		URL:      target.URI,
+		Type:     "website",
+	}
+	if strings.HasPrefix(target.URI, "@") {
+		p.Type = "profile"
+		p.URL = ""
+	}
+	return p
+}
+
+// fetchPreview builds the preview for target. It always returns a preview,
+// along with the error if the ActivityPub object couldn't be used.
+//
+// The instance's metadata is only fetched when the object can't be, as it
+// takes several requests; otherwise it's only used if it's already cached.
+func fetchPreview(ctx context.Context, target *Target) (*Preview, error) {
+	ctx, span := tracer.Start(ctx, "fetchPreview")
+	defer span.End()
+	span.SetAttributes(attribute.String("webap.domain", target.Host))
+
+	p := basicPreview(target)
+	obj, err := fetchTargetObject(ctx, target)
+	if err != nil {
+		if meta, metaErr := lookupInstanceMetadata(ctx, target.Host); metaErr == nil {
+			meta.applyTo(p)
+		}
+		return p, err
+	}
+
+	if meta, ok := cachedInstanceMetadata(ctx, target.Host); ok {
+		meta.applyTo(p)
+	}
+	obj.applyTo(ctx, p)
+	return p, nil
+}
+
+// fetchTargetObject fetches target's ActivityPub object, looking up the actor
+// with webfinger first for @user@host targets.
+func fetchTargetObject(ctx context.Context, target *Target) (*asObject, error) {
+	objectURL := target.URI
+	if strings.HasPrefix(target.URI, "@") {
+		var err error
+		if objectURL, err = webfingerActor(ctx, target); err != nil {
+			return nil, err
+		}
+	}
+	return fetchActivityObject(ctx, objectURL)
+}
+
+// applyTo fills in p's site name, and the description and image for targets
+// that don't have their own.
+func (m *instanceMetadata) applyTo(p *Preview) {
+	p.SiteName = m.Title
+	p.Description = plainText(m.Description)
+	if m.Thumbnail != nil {
+		p.Image = m.Thumbnail.URL
+	}
+}
+
+// webfingerActor looks up the ActivityPub actor URL for an @user@host target.
+func webfingerActor(ctx context.Context, target *Target) (string, error) {
+	wf := &url.URL{
+		Scheme:   "https",
+		Host:     target.Host,
+		Path:     "/.well-known/webfinger",
+		RawQuery: url.Values{"resource": {"acct:" + strings.TrimPrefix(target.URI, "@")}}.Encode(),
+	}
+	var resp struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Type string `json:"type"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := getJSON(ctx, wf.String(), "application/jrd+json, application/json", "webfinger", &resp); err != nil {
+		return "", err
+	}
+	for _, link := range resp.Links {
+		if link.Rel == "self" && isActivityPubType(link.Type) {
+			return link.Href, nil
+		}
+	}
+	return "", errors.New("webfinger has no ActivityPub actor")
+}
+
+// fetchActivityObject fetches the ActivityPub representation of rawURL.
+func fetchActivityObject(ctx context.Context, rawURL string) (*asObject, error) {
+	var obj asObject
+	if err := getJSON(ctx, rawURL, activityPubAccept, "activitypub", &obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// getJSON fetches an https URL with the given Accept header and decodes the
+// (size limited) JSON response into v. Anything that isn't JSON, like an
+// instance's HTML page, gets errNotActivityPub.
+func getJSON(ctx context.Context, rawURL, accept, what string, v any) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("unsupported %s URL scheme %q", what, u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{what, resp.StatusCode}
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasSuffix(mediaType, "json") {
+		return errNotActivityPub
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxDetectBodySize)).Decode(v)
+}
+
+func isActivityPubType(mediaType string) bool {
+	return mediaType == "application/activity+json" || strings.HasPrefix(mediaType, "application/ld+json")
+}
+
+// asText is an ActivityStreams string property. Anything else (a language map,
+// null, an array) is treated as empty rather than failing the whole document.
+type asText string
+
+func (t *asText) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*t = asText(s)
+	}
+	return nil
+}
+
+// asRef is a reference to another object or link, which ActivityStreams lets
+// be a bare URL, an embedded object, or an array of either.
+type asRef struct {
+	Type              asText          `json:"type"`
+	ID                asText          `json:"id"`
+	Href              asText          `json:"href"`
+	URL               json.RawMessage `json:"url"`
+	MediaType         asText          `json:"mediaType"`
+	Name              asText          `json:"name"`
+	PreferredUsername asText          `json:"preferredUsername"`
+}
+
+// parseRefs flattens a reference property into a list.
+func parseRefs(raw json.RawMessage) []asRef {
+	if len(raw) == 0 {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return []asRef{{Href: asText(s)}}
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var refs []asRef
+		for _, item := range list {
+			refs = append(refs, parseRefs(item)...)
+		}
+		return refs
+	}
+	var ref asRef
+	if json.Unmarshal(raw, &ref) == nil {
+		return []asRef{ref}
+	}
+	return nil
+}
+
+// id returns the ActivityPub ID a reference points at, to fetch it by.
+func (r asRef) id() string {
+	if r.ID != "" {
+		return string(r.ID)
+	}
+	return string(r.Href)
+}
+
+// link returns the URL a reference points at, preferring one for people.
+func (r asRef) link() string {
+	if r.Href != "" {
+		return string(r.Href)
+	}
+	if u := htmlLink(parseRefs(r.URL)); u != "" {
+		return u
+	}
+	return string(r.ID)
+}
+
+func (r asRef) isImage() bool {
+	return r.Type == "Image" || strings.HasPrefix(string(r.MediaType), "image/")
+}
+
+// htmlLink picks the link meant for people out of a url property, which can
+// list several representations (e.g., PeerTube's HTML page and video files).
+func htmlLink(refs []asRef) string {
+	for _, ref := range refs {
+		if ref.MediaType == "" || ref.MediaType == "text/html" {
+			return ref.link()
+		}
+	}
+	return ""
+}
+
+// firstImage returns the first image in refs.
+func firstImage(refs []asRef) (asRef, bool) {
+	for _, ref := range refs {
+		if ref.isImage() && ref.link() != "" {
+			return ref, true
+		}
+	}
+	return asRef{}, false
+}
+
+// asObject is the part of an ActivityPub object or actor a preview needs.
+type asObject struct {
+	ID                asText          `json:"id"`
+	Type              asText          `json:"type"`
+	Name              asText          `json:"name"`
+	PreferredUsername asText          `json:"preferredUsername"`
+	Summary           asText          `json:"summary"`
+	Content           asText          `json:"content"`
+	Sensitive         bool            `json:"sensitive"`
+	URL               json.RawMessage `json:"url"`
+	AttributedTo      json.RawMessage `json:"attributedTo"`
+	Icon              json.RawMessage `json:"icon"`
+	Image             json.RawMessage `json:"image"`
+	Attachment        json.RawMessage `json:"attachment"`
+}
+
+func (o *asObject) isActor() bool {
+	switch o.Type {
+	case "Person", "Service", "Application", "Group", "Organization":
+		return true
+	}
+	return false
+}
+
+// handle returns an actor's @user@host handle.
+func (o *asObject) handle() string {
+	u, err := url.Parse(string(o.ID))
+	if err != nil || o.PreferredUsername == "" || u.Host == "" {
+		return ""
+	}
+	return "@" + string(o.PreferredUsername) + "@" + u.Hostname()
+}
+
+// displayName returns an actor as "Name (@user@host)", the way Mastodon
+// titles its own previews.
+func (o *asObject) displayName() string {
+	name := strings.TrimSpace(string(o.Name))
+	if name == "" {
+		name = string(o.PreferredUsername)
+	}
+	switch handle := o.handle(); {
+	case handle == "":
+		return name
+	case name == "":
+		return handle
+	default:
+		return name + " (" + handle + ")"
+	}
+}
+
+// applyTo fills in p from the object, fetching its author if need be.
+func (o *asObject) applyTo(ctx context.Context, p *Preview) {
+	if u := htmlLink(parseRefs(o.URL)); u != "" {
+		p.URL = u
+	} else if o.ID != "" {
+		p.URL = string(o.ID)
+	}
+
+	if o.isActor() {
+		p.Type = "profile"
+		p.Title = o.displayName()
+		p.Author = o.handle()
+		p.Description = plainText(string(o.Summary))
+		if icon, ok := firstImage(parseRefs(o.Icon)); ok {
+			p.Image, p.ImageAlt, p.LargeImage = icon.link(), "", false
+		}
+		return
+	}
+
+	p.Type = "article"
+	var author *asObject
+	if refs := parseRefs(o.AttributedTo); len(refs) > 0 {
+		// PeerTube lists the channel as well as the account, we want the person.
+		ref := refs[0]
+		for _, r := range refs {
+			if r.Type == "Person" {
+				ref = r
+				break
+			}
+		}
+		if ref.PreferredUsername != "" {
+			author = &asObject{ID: ref.ID, Name: ref.Name, PreferredUsername: ref.PreferredUsername}
+		} else if actor, err := fetchActivityObject(ctx, ref.id()); err == nil && actor.isActor() {
+			author = actor
+		}
+	}
+	if author != nil {
+		p.Author = author.handle()
+	}
+
+	switch {
+	case o.Name != "":
+		p.Title = strings.TrimSpace(string(o.Name))
+	case author != nil:
+		p.Title = author.displayName()
+	}
+
+	// On microblogs the summary is a content warning, which is all a preview
+	// should show, and without any of the (possibly sensitive) media.
+	if o.Sensitive || (o.Summary != "" && o.Name == "") {
+		p.Description = "Sensitive content"
+		if warning := plainText(string(o.Summary)); warning != "" {
+			p.Description = "Content warning: " + warning
+		}
+		p.Image, p.ImageAlt, p.LargeImage = "", "", false
+		return
+	}
+
+	if text := plainText(string(o.Content)); text != "" {
+		p.Description = text
+	} else if text := plainText(string(o.Summary)); text != "" {
+		p.Description = text
+	}
+
+	if img, ok := firstImage(parseRefs(o.Attachment)); ok {
+		p.Image, p.ImageAlt, p.LargeImage = img.link(), string(img.Name), true
+	} else if img, ok := firstImage(append(parseRefs(o.Image), parseRefs(o.Icon)...)); ok {
+		// Articles have a cover image, PeerTube videos have thumbnails.
+		p.Image, p.ImageAlt, p.LargeImage = img.link(), string(img.Name), true
+	}
+}
+
+// plainText turns an HTML fragment into a single line of plain text, cut
+// down to maxPreviewDescription runes.
+func plainText(fragment string) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			text := strings.Join(strings.Fields(b.String()), " ")
+			if runes := []rune(text); len(runes) > maxPreviewDescription {
+				text = strings.TrimSpace(string(runes[:maxPreviewDescription-1])) + "…"
+			}
+			return text
+		case html.TextToken:
+			b.Write(z.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			// Keep paragraphs and line breaks from running words together.
+			b.WriteByte(' ')
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Preference categories
+const (
+	CategorySocial    = "social"
+	CategoryCommunity = "community"
+	CategoryPhoto     = "photo"
+	CategoryVideo     = "video"
+	CategoryMusic     = "music"
+	CategoryBlog      = "blog"
+)
+
+// softwareCategories maps software built around one kind of content to its
+// category, as in instance-config.js's SPECIALIZED_SOFTWARE, plus the blogging
+// platforms for the blog category. Everything else is social; the common
+// general purpose software is listed too, so this doubles as the list of
+// software we know (see SoftwareLabel).
+var softwareCategories = map[string]string{
+	"mastodon":    CategorySocial,
+	"glitch-soc":  CategorySocial,
+	"hometown":    CategorySocial,
+	"misskey":     CategorySocial,
+	"sharkey":     CategorySocial,
+	"firefish":    CategorySocial,
+	"iceshrimp":   CategorySocial,
+	"pleroma":     CategorySocial,
+	"akkoma":      CategorySocial,
+	"gotosocial":  CategorySocial,
+	"friendica":   CategorySocial,
+	"hubzilla":    CategorySocial,
+	"gnusocial":   CategorySocial,
+	"bookwyrm":    CategorySocial,
+	"pixelfed":    CategoryPhoto,
+	"lemmy":       CategoryCommunity,
+	"piefed":      CategoryCommunity,
+	"kbin":        CategoryCommunity,
+	"mbin":        CategoryCommunity,
+	"peertube":    CategoryVideo,
+	"funkwhale":   CategoryMusic,
+	"mobilizon":   CategorySocial,
+	"writefreely": CategoryBlog,
+	"plume":       CategoryBlog,
+	"wordpress":   CategoryBlog,
+}
+
+// SoftwareCategory returns the kind of content software is for.
+//
+// Parameters:
+//   - software: The software name, as from nodeinfo (e.g., "pixelfed")
+//
+// Returns:
+//   - string: The category (e.g., "photo"), "social" for general purpose or unknown software
+func SoftwareCategory(software string) string {
+	if category, ok := softwareCategories[strings.ToLower(software)]; ok {
+		return category
+	}
+	return CategorySocial
+}
+
+// SoftwareLabel returns software as a metric label value, from a fixed set so
+// remote servers can't make up new ones.
+//
+// Parameters:
+//   - software: The software name, as from nodeinfo (e.g., "pixelfed"), or "unknown"
+//
+// Returns:
+//   - string: The lowercased name if it's software we know, "unknown" as is, or "other"
+func SoftwareLabel(software string) string {
+	software = strings.ToLower(software)
+	if _, ok := softwareCategories[software]; ok || software == "unknown" {
+		return software
+	}
+	return "other"
+}
+
+// InstanceFor returns the home instance for a category, falling back to the
+// main home instance, as in storage.js's getInstanceForType.
+//
+// Parameters:
+//   - category: The category (e.g., "photo")
+//
+// Returns:
+//   - string: The instance hostname, or "" if none is set at all
+func (p *Preferences) InstanceFor(category string) string {
+	var instance string
+	switch category {
+	case CategorySocial:
+		instance = p.Social
+	case CategoryCommunity:
+		instance = p.Community
+	case CategoryPhoto:
+		instance = p.Photo
+	case CategoryVideo:
+		instance = p.Video
+	case CategoryMusic:
+		instance = p.Music
+	case CategoryBlog:
+		instance = p.Blog
+	}
+	if instance == "" {
+		return p.Home()
+	}
+	return instance
+}
+
+// Route is where to open a web+ap target.
+type Route struct {
+	// Target is the target's URI (e.g., "https://pixelfed.social/p/abc123").
+	Target string `json:"target"`
+	// Host is the instance the target lives on.
+	Host string `json:"host"`
+	// Software is what Host runs, or "unknown".
+	Software string `json:"software"`
+	// Category is the kind of content, from Software.
+	Category string `json:"category"`
+	// Home is the home instance for Category, empty if none is set.
+	Home string `json:"home,omitempty"`
+	// RedirectURL opens the target on Home.
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// Route picks the home instance to open target on, by the kind of software
+// hosting it.
+//
+// Parameters:
+//   - target: The web+ap target
+//   - software: The software target.Host runs (e.g., "pixelfed"), or "unknown"
+//
+// Returns:
+//   - *Route: Where to go. Home and RedirectURL are empty if no home instance is set
+func (p *Preferences) Route(target *Target, software string) *Route {
+	route := &Route{
+		Target:   target.URI,
+		Host:     target.Host,
+		Software: software,
+		Category: SoftwareCategory(software),
+	}
+	route.Home = p.InstanceFor(route.Category)
+	if route.Home != "" {
+		route.RedirectURL = target.AuthorizeURL(route.Home)
+	}
+	return route
+}
+
+// RouteHandler works out which home instance a web+ap target should be opened
+// on. The target's host is classified by its software (see /api/software), so
+// Pixelfed posts go to the photo home, PeerTube videos to the video home, Lemmy
+// threads to the community home, and so on.
+//
+// Home instances come from the preferences cookie, and can be overridden per
+// category with query parameters (handy without the cookie, e.g. from another
+// site). If the software can't be detected, the target is treated as social.
+//
+// Query Parameters:
+//   - uri: The web+ap target (e.g., "web+ap://pixelfed.social/p/abc123")
+//   - social, community, photo, video, music, blog: Home instance overrides (optional)
+//
+// Response (200 OK):
+//
+//	{
+//	  "target": "https://pixelfed.social/p/abc123",
+//	  "host": "pixelfed.social",
+//	  "software": "pixelfed",
+//	  "category": "photo",
+//	  "home": "pixey.org",
+//	  "redirect_url": "https://pixey.org/authorize_interaction?uri=https%3A%2F%2Fpixelfed.social%2Fp%2Fabc123"
+//	}
+//
+// Errors:
+//   - 400 Bad Request: Missing or invalid uri, or an invalid override
+//   - 405 Method Not Allowed: Non-GET request
+func RouteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	raw := query.Get("uri")
+	if raw == "" {
+		http.Error(w, "Missing uri parameter", http.StatusBadRequest)
+		return
+	}
+	target, err := ParseTarget(raw)
+	if err != nil {
+		http.Error(w, "Invalid uri parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	prefs, _ := RequestPreferences(r)
+	overrides := map[string]*string{
+		CategorySocial:    &prefs.Social,
+		CategoryCommunity: &prefs.Community,
+		CategoryPhoto:     &prefs.Photo,
+		CategoryVideo:     &prefs.Video,
+		CategoryMusic:     &prefs.Music,
+		CategoryBlog:      &prefs.Blog,
+	}
+	for category, instance := range overrides {
+		value := query.Get(category)
+		if value == "" {
+			continue
+		}
+		if *instance, err = normalizeInstance(value); err != nil {
+			http.Error(w, "Invalid "+category+" parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	software := "unknown"
+	if info, err := lookupSoftware(r.Context(), target.Host); err != nil {
+		slog.DebugContext(r.Context(), "couldn't detect target software, routing as social",
+			"host", target.Host, "error", err)
+	} else if info.Software != "" {
+		software = info.Software
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	_ = json.NewEncoder(w).Encode(prefs.Route(target, software))
+}
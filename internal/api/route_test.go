@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import "testing"
+
+func TestSoftwareLabel(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "mastodon", want: "mastodon"},
+		{input: "Pixelfed", want: "pixelfed"},
+		{input: "wordpress", want: "wordpress"},
+		{input: "unknown", want: "unknown"},
+		{input: "", want: "other"},
+		{input: "my-fork-of-mastodon", want: "other"},
+		{input: "Hugo", want: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := SoftwareLabel(tt.input); got != tt.want {
+				t.Errorf("SoftwareLabel(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
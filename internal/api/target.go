@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Target errors
+var (
+	errInvalidTarget = errors.New("not a web+ap target")
+	errTargetPort    = errors.New("targets can't have a port")
+)
+
+// acctUserPattern matches the user part of an @user@host handle.
+var acctUserPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// fileLikeSuffixes are real TLDs that are far more often a file extension.
+// A bare "notes.zip" or "clip.mov" is a scanner probe or a stray link, not an
+// instance, so those are only taken as targets with a scheme, a path or as a
+// handle's host. Country codes that double as extensions (.sh, .py, .md, ...)
+// are left alone, as there are real instances under them.
+var fileLikeSuffixes = []string{"zip", "mov"}
+
+// Target is the fediverse object or actor a web+ap link points at.
+type Target struct {
+	// Host is the instance it lives on, normalised (e.g., "pixelfed.social").
+	Host string
+	// URI is what to hand the home instance's authorize_interaction, either a
+	// URL (e.g., "https://pixelfed.social/p/abc123") or a handle (e.g.,
+	// "@user@mastodon.social").
+	URI string
+}
+
+// ParseTarget parses the target of a web+ap link, as it appears after the
+// domain in a webap.to URL or in authorize_interaction's uri parameter.
+//
+// Accepted forms, with or without a web+ap://, https:// or http:// scheme (or
+// a wrapping link to the domain set by SetPublicDomain):
+//   - host/path (e.g., "pixelfed.social/p/abc123")
+//   - @user@host (e.g., "@user@mastodon.social")
+//
+// The host has to be a valid hostname under a real public suffix, which keeps
+// typos and scanner probes like "wp-login.php" from looking like targets. It
+// can't have a port, as there'd be no way to pass it on to the home instance.
+// A bare host under one of fileLikeSuffixes (e.g., "backup.zip") isn't taken
+// as a target either.
+//
+// Parameters:
+//   - raw: The target (e.g., "web+ap://pixelfed.social/p/abc123")
+//
+// Returns:
+//   - *Target: The parsed target
+//   - error: If raw isn't a valid web+ap target
+func ParseTarget(raw string) (*Target, error) {
+	target := strings.TrimPrefix(strings.TrimSpace(raw), "/")
+
+	// Path cleaning can squash "https://" down to "https:/", so be lenient
+	// about the slashes.
+	schemed := false
+	if scheme, rest, ok := strings.Cut(target, ":"); ok {
+		switch strings.ToLower(scheme) {
+		case "web+ap", "https", "http":
+			target = strings.TrimLeft(rest, "/")
+			schemed = true
+		}
+	}
+	if domain := currentPublicDomain(); domain != "" {
+		if first, rest, ok := strings.Cut(target, "/"); ok {
+			host, _, _ := strings.Cut(first, ":")
+			if strings.EqualFold(host, domain) {
+				target, schemed = rest, false
+			}
+		}
+	}
+
+	first, rest, _ := strings.Cut(target, "/")
+	if first == "" {
+		return nil, errInvalidTarget
+	}
+
+	if handle, ok := strings.CutPrefix(first, "@"); ok {
+		user, host, ok := strings.Cut(handle, "@")
+		if !ok || rest != "" || !acctUserPattern.MatchString(user) {
+			return nil, errInvalidTarget
+		}
+		host, err := targetHost(host)
+		if err != nil {
+			return nil, err
+		}
+		return &Target{Host: host, URI: "@" + user + "@" + host}, nil
+	}
+
+	if strings.ContainsAny(first, "@?#") {
+		return nil, errInvalidTarget
+	}
+	host, err := targetHost(first)
+	if err != nil {
+		return nil, err
+	}
+	if !schemed && rest == "" && slices.Contains(fileLikeSuffixes, tld(host)) {
+		return nil, errInvalidTarget
+	}
+
+	uri := "https://" + host
+	if rest != "" {
+		uri += "/" + rest
+	}
+	return &Target{Host: host, URI: uri}, nil
+}
+
+// targetHost normalises a target's host, which must be under a known public
+// suffix (an ICANN TLD, or a private suffix like github.io) and have no port.
+func targetHost(raw string) (string, error) {
+	if strings.Contains(raw, ":") && !strings.HasPrefix(raw, "[") {
+		return "", errTargetPort
+	}
+	host, err := normalizeInstance(raw)
+	if err != nil {
+		return "", err
+	}
+	suffix, icann := publicsuffix.PublicSuffix(host)
+	if !icann && !strings.Contains(suffix, ".") {
+		return "", errInvalidTarget
+	}
+	return host, nil
+}
+
+// tld returns the last label of host.
+func tld(host string) string {
+	return host[strings.LastIndex(host, ".")+1:]
+}
+
+// AuthorizeURL returns the URL that opens the target on a home instance, via
+// its authorize_interaction endpoint.
+//
+// Parameters:
+//   - home: The home instance's hostname (e.g., "aus.social")
+//
+// Returns:
+//   - string: The URL (e.g., "https://aus.social/authorize_interaction?uri=https%3A%2F%2Fpixelfed.social%2Fp%2Fabc123")
+func (t *Target) AuthorizeURL(home string) string {
+	return "https://" + home + "/authorize_interaction?uri=" + url.QueryEscape(t.URI)
+}
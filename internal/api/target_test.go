@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	SetPublicDomain("webap.example")
+	t.Cleanup(func() { SetPublicDomain("") })
+
+	tests := []struct {
+		name    string
+		input   string
+		want    Target
+		wantErr error
+	}{
+		{name: "host and path", input: "pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "leading slash", input: "/pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "bare host", input: "mastodon.social", want: Target{Host: "mastodon.social", URI: "https://mastodon.social"}},
+		{name: "web+ap scheme", input: "web+ap://pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "https scheme", input: "https://pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "cleaned https scheme", input: "https:/mastodon.social/@user/123", want: Target{Host: "mastodon.social", URI: "https://mastodon.social/@user/123"}},
+		{name: "cleaned web+ap scheme", input: "web+ap:/mastodon.social/@user/123", want: Target{Host: "mastodon.social", URI: "https://mastodon.social/@user/123"}},
+		{name: "wrapped in public domain", input: "https://webap.example/pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "wrapped in public domain with port", input: "http://WebAP.example:9847/pixelfed.social/p/abc123", want: Target{Host: "pixelfed.social", URI: "https://pixelfed.social/p/abc123"}},
+		{name: "wrapped in other domain", input: "https://webap.to/pixelfed.social/p/abc123", want: Target{Host: "webap.to", URI: "https://webap.to/pixelfed.social/p/abc123"}},
+		{name: "uppercase host", input: "web+ap://Mastodon.Social/@User", want: Target{Host: "mastodon.social", URI: "https://mastodon.social/@User"}},
+		{name: "query", input: "mastodon.social/search?q=x", want: Target{Host: "mastodon.social", URI: "https://mastodon.social/search?q=x"}},
+		{name: "private suffix", input: "someone.github.io/post", want: Target{Host: "someone.github.io", URI: "https://someone.github.io/post"}},
+		{name: "handle", input: "@user@mastodon.social", want: Target{Host: "mastodon.social", URI: "@user@mastodon.social"}},
+		{name: "handle with scheme", input: "web+ap://@user@Mastodon.Social", want: Target{Host: "mastodon.social", URI: "@user@mastodon.social"}},
+		{name: "file-like tld with scheme", input: "web+ap://notes.zip", want: Target{Host: "notes.zip", URI: "https://notes.zip"}},
+		{name: "file-like tld with path", input: "clip.mov/@user/123", want: Target{Host: "clip.mov", URI: "https://clip.mov/@user/123"}},
+		{name: "file-like tld handle", input: "@user@notes.zip", want: Target{Host: "notes.zip", URI: "@user@notes.zip"}},
+		{name: "cctld extension", input: "deploy.sh", want: Target{Host: "deploy.sh", URI: "https://deploy.sh"}},
+
+		{name: "empty", input: "", wantErr: errInvalidTarget},
+		{name: "only scheme", input: "web+ap://", wantErr: errInvalidTarget},
+		{name: "wp-login.php", input: "wp-login.php", wantErr: errInvalidTarget},
+		{name: "robots.txt", input: "robots.txt", wantErr: errInvalidTarget},
+		{name: "favicon.ico", input: "favicon.ico", wantErr: errInvalidTarget},
+		{name: "zip", input: "notes.zip", wantErr: errInvalidTarget},
+		{name: "mov", input: "/Clip.MOV", wantErr: errInvalidTarget},
+		{name: "zip wrapped in public domain", input: "https://webap.example/backup.zip", wantErr: errInvalidTarget},
+		{name: "dotfile", input: ".env", wantErr: errInvalidDomain},
+		{name: "dotfile path", input: ".git/config", wantErr: errInvalidDomain},
+		{name: "single label", input: "localhost/admin", wantErr: errSingleLabel},
+		{name: "reserved tld", input: "example.invalid/x", wantErr: errInvalidTarget},
+		{name: "ip", input: "127.0.0.1/x", wantErr: errIPDomain},
+		{name: "ipv6", input: "[::1]/x", wantErr: errIPDomain},
+		{name: "port", input: "mastodon.social:8443/x", wantErr: errTargetPort},
+		{name: "port with scheme", input: "https://mastodon.social:443/x", wantErr: errTargetPort},
+		{name: "handle with port", input: "@user@mastodon.social:8443", wantErr: errTargetPort},
+		{name: "handle with path", input: "@user@mastodon.social/extra", wantErr: errInvalidTarget},
+		{name: "handle without host", input: "@user", wantErr: errInvalidTarget},
+		{name: "handle with bad user", input: "@us er@mastodon.social", wantErr: errInvalidTarget},
+		{name: "userinfo", input: "user@mastodon.social/x", wantErr: errInvalidTarget},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTarget(tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseTarget(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTarget(%q) unexpected error: %v", tt.input, err)
+			}
+			if *got != tt.want {
+				t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.input, *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package assets serves the static frontend with content-hashed URLs.
+//
+// At startup every file is read into memory and given a hashed alias (e.g.
+// /css/styles.css is also served as /css/styles.3fa9c2d1e0.css). References
+// to other assets in HTML, CSS, JavaScript and JSON are rewritten to the
+// hashed paths, and the service worker's cache name is derived from the
+// content of everything, so a deploy changes exactly the URLs whose content
+// changed. Hashed paths are cached forever, everything else is revalidated.
+// Text assets are also compressed up front with Brotli and gzip, so serving
+// them compressed costs nothing per request.
+//
+// Third-party libraries (see Dependencies) are referenced by their vendored
+// paths and served like any other asset, or rewritten to CDN URLs with
+// WithCDN. Pages get an import map, so components can import "lit"
+// without caring which.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// hashLength is how many hex characters of the SHA-256 go in hashed paths.
+	hashLength = 10
+
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// textExtensions are the file types whose references to other assets are rewritten.
+var textExtensions = []string{".html", ".css", ".js", ".json"}
+
+// assetRefPattern matches quoted or url()-wrapped absolute and relative paths
+// with a file extension, e.g. "/js/storage.js", './instance-config.js' or
+// url(../images/webap.svg).
+var assetRefPattern = regexp.MustCompile(`(["'(])(\.{0,2}/[A-Za-z0-9_\-./]+\.[A-Za-z0-9]+)(["')])`)
+
+// cacheNamePattern matches the service worker's cache name declaration.
+var cacheNamePattern = regexp.MustCompile(`(const CACHE_NAME = ')[^']*(')`)
+
+// Asset is a single servable file.
+type Asset struct {
+	// Path is the path the asset is served at.
+	Path string
+	// Content is the file content, with references to other assets rewritten.
+	Content []byte
+	// ContentType is the value of the Content-Type header.
+	ContentType string
+	// ETag is a strong validator derived from Content.
+	ETag string
+	// Immutable is set for hashed paths, whose content can never change.
+	Immutable bool
+	// Brotli and Gzip are the precompressed variants of Content, nil if
+	// compressing isn't worth it.
+	Brotli []byte
+	Gzip   []byte
+}
+
+// Pipeline holds the processed static assets.
+type Pipeline struct {
+	// dev serves straight from fsys, without hashing, for frontend development.
+	dev  bool
+	fsys fs.FS
+	// cdn loads dependencies from their CDNs even if they're vendored.
+	cdn bool
+
+	assets  map[string]*Asset // by served path
+	hashed  map[string]string // original path -> hashed path
+	deps    map[string]string // dependency path -> URL to load it from
+	version string
+
+	importMap     string
+	scriptSources []string
+	styleSources  []string
+}
+
+// New reads every file in fsys and builds the hashed asset set.
+//
+// Parameters:
+//   - fsys: The static files, rooted at the static directory
+//   - opts: Options (e.g., WithCDN)
+//
+// Returns:
+//   - *Pipeline: The processed assets
+//   - error: If fsys couldn't be read, or a vendored dependency is missing
+func New(fsys fs.FS, opts ...Option) (*Pipeline, error) {
+	p := &Pipeline{
+		fsys:   fsys,
+		assets: map[string]*Asset{},
+		hashed: map[string]string{},
+		deps:   map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	b := &builder{
+		sources: map[string][]byte{},
+		done:    map[string]string{},
+		p:       p,
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		b.sources["/"+name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(b.sources))
+	for name := range b.sources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if err := b.setupDependencies(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if name != serviceWorkerPath {
+			b.resolve(name)
+		}
+	}
+
+	// The version covers every other asset, so the service worker (and with it
+	// the cache name) changes whenever anything does.
+	version := sha256.New()
+	for _, name := range names {
+		if a, ok := b.p.assets[name]; ok {
+			version.Write([]byte(name))
+			version.Write([]byte(a.ETag))
+		}
+	}
+	b.p.version = hex.EncodeToString(version.Sum(nil))[:hashLength]
+	if _, ok := b.sources[serviceWorkerPath]; ok {
+		b.resolve(serviceWorkerPath)
+	}
+
+	return b.p, nil
+}
+
+// NewDev serves fsys as-is, re-reading files on every request and never
+// caching, so frontend changes show up on reload.
+//
+// Parameters:
+//   - fsys: The static files, rooted at the static directory
+//   - opts: Options (e.g., WithCDN)
+//
+// Returns:
+//   - *Pipeline: A pipeline that doesn't hash anything
+//   - error: If a vendored dependency is missing
+func NewDev(fsys fs.FS, opts ...Option) (*Pipeline, error) {
+	p := &Pipeline{dev: true, fsys: fsys, deps: map[string]string{}, version: "dev"}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if err := (&builder{p: p}).setupDependencies(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Version identifies the current set of assets.
+func (p *Pipeline) Version() string {
+	return p.version
+}
+
+// Path returns the URL to reference an asset by, which is its hashed path if
+// it has one.
+//
+// Parameters:
+//   - name: The asset's original path (e.g., "/css/styles.css")
+//
+// Returns:
+//   - string: The path to use in links (e.g., "/css/styles.3fa9c2d1e0.css")
+func (p *Pipeline) Path(name string) string {
+	if hashed, ok := p.hashed[name]; ok {
+		return hashed
+	}
+	return name
+}
+
+// Lookup returns the asset served at the given path.
+//
+// Parameters:
+//   - name: The request path (e.g., "/css/styles.css"), "/" meaning "/index.html"
+//
+// Returns:
+//   - *Asset: The asset
+//   - bool: Whether there is one
+func (p *Pipeline) Lookup(name string) (*Asset, bool) {
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+
+	if p.dev {
+		data, err := fs.ReadFile(p.fsys, strings.TrimPrefix(name, "/"))
+		if err != nil {
+			return nil, false
+		}
+		return newAsset(name, (&builder{p: p}).process(name, data), false), true
+	}
+
+	a, ok := p.assets[name]
+	return a, ok
+}
+
+// ServeHTTP serves the asset at r.URL.Path, or a 404.
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, ok := p.Lookup(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	a.ServeHTTP(w, r)
+}
+
+// ServeHTTP serves the asset, compressed if the client accepts it, answering
+// conditional and range requests.
+func (a *Asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	if a.Immutable {
+		h.Set("Cache-Control", immutableCacheControl)
+	} else {
+		h.Set("Cache-Control", revalidateCacheControl)
+	}
+
+	etag := a.ETag
+	coding, content := a.encoding(r)
+	if a.Brotli != nil || a.Gzip != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if coding != "" {
+		h.Set("Content-Encoding", coding)
+		// Each representation needs its own validator.
+		etag = strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+	}
+	h.Set("ETag", etag)
+
+	http.ServeContent(w, r, a.Path, time.Time{}, bytes.NewReader(content))
+}
+
+// ServeStatus serves the asset as the body of an error response (e.g., a 404
+// page), compressed if the client accepts it but never cached.
+//
+// Parameters:
+//   - w: The response writer
+//   - r: The request
+//   - status: The HTTP status code to respond with
+func (a *Asset) ServeStatus(w http.ResponseWriter, r *http.Request, status int) {
+	h := w.Header()
+	h.Set("Content-Type", a.ContentType)
+	h.Set("Cache-Control", "no-store")
+
+	coding, content := a.encoding(r)
+	if a.Brotli != nil || a.Gzip != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if coding != "" {
+		h.Set("Content-Encoding", coding)
+	}
+	h.Set("Content-Length", strconv.Itoa(len(content)))
+
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(content)
+	}
+}
+
+func newAsset(name string, content []byte, immutable bool) *Asset {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	sum := sha256.Sum256(content)
+	return &Asset{
+		Path:        name,
+		Content:     content,
+		ContentType: contentType,
+		ETag:        `"` + hex.EncodeToString(sum[:])[:2*hashLength] + `"`,
+		Immutable:   immutable,
+	}
+}
+
+// serviceWorkerPath is the service worker, whose cache name is rewritten to
+// the asset version.
+const serviceWorkerPath = "/sw.js"
+
+// hashable reports whether an asset gets a hashed alias. Pages, the service
+// worker, the manifest and robots.txt need stable URLs, as do the embeddable scripts in
+// /dist/ that other sites link to directly.
+func hashable(name string) bool {
+	switch {
+	case path.Ext(name) == ".html",
+		name == serviceWorkerPath,
+		name == "/manifest.json",
+		name == "/robots.txt",
+		strings.HasPrefix(name, "/dist/"),
+		strings.HasSuffix(name, ".license"):
+		return false
+	}
+	return true
+}
+
+// hashedName inserts hash before name's extension.
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+type builder struct {
+	sources map[string][]byte
+	done    map[string]string // original path -> path to reference it by
+	p       *Pipeline
+}
+
+// resolve processes an asset (and, first, everything it references) and
+// returns the path to reference it by.
+func (b *builder) resolve(name string) string {
+	if b.p.dev {
+		return name
+	}
+	if ref, ok := b.done[name]; ok {
+		return ref
+	}
+	// Reference cycles fall back to the unhashed path, which is still served.
+	b.done[name] = name
+
+	content := b.process(name, b.sources[name])
+	asset := newAsset(name, content, false)
+	asset.compress()
+	b.p.assets[name] = asset
+
+	if hashable(name) {
+		sum := sha256.Sum256(content)
+		hashed := hashedName(name, hex.EncodeToString(sum[:])[:hashLength])
+		alias := *asset
+		alias.Path = hashed
+		alias.Immutable = true
+		b.p.assets[hashed] = &alias
+		b.p.hashed[name] = hashed
+		b.done[name] = hashed
+	}
+
+	return b.done[name]
+}
+
+// process rewrites an asset's references to other assets, fills in the import
+// map on pages and the cache name in the service worker.
+func (b *builder) process(name string, content []byte) []byte {
+	if slices.Contains(textExtensions, path.Ext(name)) {
+		content = b.rewrite(name, content)
+	}
+	if path.Ext(name) == ".html" {
+		content = bytes.ReplaceAll(content, []byte(importMapTag), []byte(b.p.importMap))
+	}
+	if name == serviceWorkerPath {
+		content = cacheNamePattern.ReplaceAll(content, []byte("${1}webap-"+b.p.version+"${2}"))
+	}
+	return content
+}
+
+// exists reports whether there's an asset at name.
+func (b *builder) exists(name string) bool {
+	if b.p.dev {
+		_, err := fs.Stat(b.p.fsys, strings.TrimPrefix(name, "/"))
+		return err == nil
+	}
+	_, ok := b.sources[name]
+	return ok
+}
+
+// rewrite replaces references to other assets in content with the paths
+// they should be referenced by.
+func (b *builder) rewrite(name string, content []byte) []byte {
+	return assetRefPattern.ReplaceAllFunc(content, func(m []byte) []byte {
+		parts := assetRefPattern.FindSubmatch(m)
+		ref := string(parts[2])
+
+		target := ref
+		if strings.HasPrefix(ref, ".") {
+			target = path.Join(path.Dir(name), ref)
+		}
+
+		var resolved string
+		if ref, ok := b.p.deps[target]; ok {
+			resolved = ref
+		} else if target != name && b.exists(target) {
+			resolved = b.resolve(target)
+		}
+		if resolved == "" || resolved == target {
+			return m
+		}
+		return []byte(string(parts[1]) + resolved + string(parts[3]))
+	})
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// minCompressSize is the smallest file worth compressing. Below it, the
+// framing overhead eats most of the saving.
+const minCompressSize = 512
+
+// compressible reports whether content of the given type is worth compressing.
+// Images other than SVG are already compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/manifest+json",
+		mediaType == "application/javascript",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compress precomputes the asset's Brotli and gzip variants, keeping each only
+// if it's actually smaller.
+func (a *Asset) compress() {
+	if len(a.Content) < minCompressSize || !compressible(a.ContentType) {
+		return
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	if _, err := bw.Write(a.Content); err == nil && bw.Close() == nil && br.Len() < len(a.Content) {
+		a.Brotli = br.Bytes()
+	}
+
+	var gz bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if _, err := gw.Write(a.Content); err == nil && gw.Close() == nil && gz.Len() < len(a.Content) {
+		a.Gzip = gz.Bytes()
+	}
+}
+
+// encoding picks the best representation of the asset for r.
+//
+// Returns:
+//   - string: The Content-Encoding, "" for identity
+//   - []byte: The encoded content
+func (a *Asset) encoding(r *http.Request) (string, []byte) {
+	if a.Brotli != nil && AcceptsEncoding(r, "br") {
+		return "br", a.Brotli
+	}
+	if a.Gzip != nil && AcceptsEncoding(r, "gzip") {
+		return "gzip", a.Gzip
+	}
+	return "", a.Content
+}
+
+// AcceptsEncoding reports whether r's Accept-Encoding header allows the given
+// content coding, honouring "q=0" exclusions and the "*" wildcard.
+//
+// Parameters:
+//   - r: The request
+//   - coding: The content coding (e.g., "gzip")
+//
+// Returns:
+//   - bool: Whether the client accepts responses in that coding
+func AcceptsEncoding(r *http.Request, coding string) bool {
+	wildcard := false
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			name = strings.TrimSpace(name)
+
+			accepted := true
+			for _, param := range strings.Split(params, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if ok && strings.EqualFold(key, "q") {
+					if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+						accepted = false
+					}
+				}
+			}
+
+			switch {
+			case strings.EqualFold(name, coding):
+				return accepted
+			case name == "*":
+				wildcard = accepted
+			}
+		}
+	}
+	return wildcard
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Dependency is a third-party frontend library. It's served from our own copy
+// in static/vendor/ (fetched with `make vendor`, which checks it against
+// static/vendor/SHA256SUMS), or from its CDN with WithCDN.
+type Dependency struct {
+	// Path is where the vendored copy lives (e.g., "/vendor/lit/lit-all.min.js").
+	Path string
+	// CDN is the URL to load it from instead.
+	CDN string
+	// Specifiers are the bare module specifiers that map to it in the import
+	// map (e.g., "lit"), if it's a JavaScript module.
+	Specifiers []string
+}
+
+// Dependencies are the frontend's third-party libraries, pinned to exact
+// versions. Keep in sync with LIT_VERSION and OPEN_PROPS_VERSION in the
+// Makefile.
+var Dependencies = []Dependency{
+	{
+		// The all-in-one bundle includes the directives, so it covers both imports.
+		Path:       "/vendor/lit/lit-all.min.js",
+		CDN:        "https://cdn.jsdelivr.net/gh/lit/dist@3.3.1/all/lit-all.min.js",
+		Specifiers: []string{"lit", "lit/directives/unsafe-html.js"},
+	},
+	{
+		Path: "/vendor/open-props/open-props.min.css",
+		CDN:  "https://unpkg.com/open-props@1.7.16/open-props.min.css",
+	},
+	{
+		Path: "/vendor/open-props/normalize.min.css",
+		CDN:  "https://unpkg.com/open-props@1.7.16/normalize.min.css",
+	},
+}
+
+// importMapTag is the placeholder pages leave for the import map.
+const importMapTag = `<script type="importmap"></script>`
+
+// Option configures a Pipeline.
+type Option func(*Pipeline)
+
+// WithCDN loads third-party dependencies from their CDNs instead of the
+// vendored copies, which then don't need to exist.
+func WithCDN() Option {
+	return func(p *Pipeline) {
+		p.cdn = true
+	}
+}
+
+// ScriptSources returns the Content-Security-Policy script-src sources the
+// pages need on top of 'self': the import map's hash and any CDNs in use.
+func (p *Pipeline) ScriptSources() []string {
+	return p.scriptSources
+}
+
+// StyleSources returns the Content-Security-Policy style-src sources the pages
+// need on top of 'self', i.e. any CDNs in use.
+func (p *Pipeline) StyleSources() []string {
+	return p.styleSources
+}
+
+// dependency returns the third-party dependency vendored at name, if any.
+func dependency(name string) (Dependency, bool) {
+	for _, dep := range Dependencies {
+		if dep.Path == name {
+			return dep, true
+		}
+	}
+	return Dependency{}, false
+}
+
+// setupDependencies decides where each dependency is loaded from, renders the
+// import map, and works out the CSP sources that takes.
+//
+// Returns:
+//   - error: If a vendored copy is missing and the CDNs aren't in use
+func (b *builder) setupDependencies() error {
+	p := b.p
+	imports := map[string]string{}
+	p.scriptSources = nil
+	p.styleSources = nil
+
+	for _, dep := range Dependencies {
+		ref := dep.CDN
+		if !p.cdn {
+			if _, err := fs.Stat(p.fsys, strings.TrimPrefix(dep.Path, "/")); err != nil {
+				return fmt.Errorf("vendored frontend dependency %s missing (run `make vendor`, or set FRONTEND_CDN=true): %w", dep.Path, err)
+			}
+			ref = b.resolve(dep.Path)
+		} else {
+			if u, err := url.Parse(dep.CDN); err == nil {
+				origin := u.Scheme + "://" + u.Host
+				sources := &p.styleSources
+				if path.Ext(dep.Path) == ".js" {
+					sources = &p.scriptSources
+				}
+				if !slices.Contains(*sources, origin) {
+					*sources = append(*sources, origin)
+				}
+			}
+		}
+		p.deps[dep.Path] = ref
+
+		for _, specifier := range dep.Specifiers {
+			imports[specifier] = ref
+		}
+	}
+
+	// The map is inlined into every page, so the CSP has to allow it by hash.
+	importMap, _ := json.Marshal(map[string]any{"imports": imports})
+	sum := sha256.Sum256(importMap)
+	p.importMap = `<script type="importmap">` + string(importMap) + `</script>`
+	p.scriptSources = append([]string{"'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"}, p.scriptSources...)
+	return nil
+}
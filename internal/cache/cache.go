@@ -4,13 +4,20 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
+
+	"webap.to/internal/metrics"
 )
 
-const cacheTTL = 30 * 24 * time.Hour
+const (
+	cacheTTL    = 30 * 24 * time.Hour
+	metadataTTL = 24 * time.Hour
+)
 
 // Various errors
 var (
@@ -24,11 +31,38 @@ var (
 //   - Domain: The instance domain (e.g., "mastodon.social")
 //   - Software: The server software name, lowercase (e.g., "mastodon", "pleroma")
 //   - Version: The software version string (e.g., "4.2.0")
+//   - SchemaVersion: The nodeinfo schema version the info came from (e.g., "2.1")
+//   - Detector: Which detector identified the software (e.g., "nodeinfo", "mastodon-api-v2")
 //   - CachedAt: When this info was fetched and cached
 type InstanceInfo struct {
+	Domain        string
+	Software      string
+	Version       string
+	SchemaVersion string
+	Detector      string
+	CachedAt      time.Time
+}
+
+// Expired reports whether the info is older than the cache TTL (30 days).
+//
+// Expired entries may still be returned by Get once, while they are being
+// removed from the store.
+func (i *InstanceInfo) Expired() bool {
+	return time.Since(i.CachedAt) > cacheTTL
+}
+
+// InstanceMetadata holds the cached, normalised instance metadata document
+// (title, description, rules, ...) for a fediverse instance.
+//
+// The cache treats Data as opaque; it is encoded and decoded by the API layer.
+//
+// Fields:
+//   - Domain: The instance domain (e.g., "mastodon.social")
+//   - Data: The JSON encoded metadata document
+//   - CachedAt: When this metadata was fetched and cached
+type InstanceMetadata struct {
 	Domain   string
-	Software string
-	Version  string
+	Data     []byte
 	CachedAt time.Time
 }
 
@@ -40,7 +74,8 @@ type InstanceInfo struct {
 //   - mongodb://... or mongodb+srv://... → MongoDB
 //   - anything else → SQLite (file path, file:... or ":memory:")
 //
-// Cache entries expire after 30 days and are lazily deleted on access.
+// Instance info entries expire after 30 days, and instance metadata entries
+// after 24 hours. Both are lazily deleted on access.
 type Cache interface {
 	// Get retrieves cached instance info by domain.
 	//
@@ -48,30 +83,76 @@ type Cache interface {
 	// asynchronously deleted from the store.
 	//
 	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
 	//   - domain: The instance domain (e.g., "mastodon.social")
 	//
 	// Returns:
 	//   - *InstanceInfo: The cached info, or nil if not found/expired
 	//   - err: if an error occurred, else nil
-	Get(domain string) (*InstanceInfo, error)
+	Get(ctx context.Context, domain string) (*InstanceInfo, error)
 
 	// Set stores or updates instance info in the cache.
 	//
 	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
 	//   - info: The instance info to cache. Must have Domain set.
 	//
 	// Returns:
 	//   - error: Any storage error
-	Set(info *InstanceInfo) error
+	Set(ctx context.Context, info *InstanceInfo) error
 
 	// Delete removes instance info from the cache.
 	//
 	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
+	//   - domain: The instance domain to remove (e.g., "mastodon.social")
+	//
+	// Returns:
+	//   - error: Any storage error (returns nil if domain wasn't cached)
+	Delete(ctx context.Context, domain string) error
+
+	// GetMetadata retrieves cached instance metadata by domain.
+	//
+	// Expired entries (older than 24 hours) are treated as not found and
+	// deleted from the store.
+	//
+	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
+	//   - domain: The instance domain (e.g., "mastodon.social")
+	//
+	// Returns:
+	//   - *InstanceMetadata: The cached metadata, or nil if not found/expired
+	//   - err: if an error occurred, else nil
+	GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error)
+
+	// SetMetadata stores or updates instance metadata in the cache.
+	//
+	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
+	//   - meta: The metadata to cache. Must have Domain set.
+	//
+	// Returns:
+	//   - error: Any storage error
+	SetMetadata(ctx context.Context, meta *InstanceMetadata) error
+
+	// DeleteMetadata removes instance metadata from the cache.
+	//
+	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
 	//   - domain: The instance domain to remove (e.g., "mastodon.social")
 	//
 	// Returns:
 	//   - error: Any storage error (returns nil if domain wasn't cached)
-	Delete(domain string) error
+	DeleteMetadata(ctx context.Context, domain string) error
+
+	// Ping checks that the underlying store is reachable.
+	//
+	// Parameters:
+	//   - ctx: Request context, used for cancellation and log correlation
+	//
+	// Returns:
+	//   - error: Any error reaching the store, nil if healthy
+	Ping(ctx context.Context) error
 
 	// Close closes the underlying database connection and releases resources.
 	//
@@ -84,9 +165,11 @@ type Cache interface {
 type Factory func(dsn string) (Cache, error)
 
 type cache struct {
-	store Cache
-	mu    sync.RWMutex
-	ttl   time.Duration
+	store       Cache
+	backend     string
+	mu          sync.RWMutex
+	ttl         time.Duration
+	metadataTTL time.Duration
 }
 
 // New creates a new cache with the given database connection string.
@@ -108,8 +191,10 @@ func New(dsn string) (Cache, error) {
 	}
 
 	return &cache{
-		store: store,
-		ttl:   cacheTTL,
+		store:       store,
+		backend:     backendName(store),
+		ttl:         cacheTTL,
+		metadataTTL: metadataTTL,
 	}, nil
 }
 
@@ -148,22 +233,61 @@ func routeStore(dsn string) (Cache, error) {
 	return match(dsn)
 }
 
+// backendName returns the metrics label for a store.
+func backendName(store Cache) string {
+	switch store.(type) {
+	case *sqliteStore:
+		return "sqlite"
+	case *postgresStore:
+		return "postgres"
+	case *mySQLStore:
+		return "mysql"
+	case *mongoDBStore:
+		return "mongodb"
+	default:
+		return "other"
+	}
+}
+
+// observe records the duration of a store operation started at start, and logs
+// it against the request in ctx (at warn level if it failed). Failures are
+// also recorded on the current span.
+func (c *cache) observe(ctx context.Context, operation string, start time.Time, err error) {
+	d := time.Since(start)
+	metrics.CacheDuration.WithLabelValues(c.backend, operation).Observe(d.Seconds())
+	recordError(ctx, err)
+
+	if err != nil {
+		slog.WarnContext(ctx, "cache operation failed",
+			"backend", c.backend, "operation", operation, "duration", d, "error", err)
+		return
+	}
+	slog.DebugContext(ctx, "cache operation",
+		"backend", c.backend, "operation", operation, "duration", d)
+}
+
 // Get retrieves cached instance info by domain.
 //
 // Expired entries (older than 30 days) are treated as not found and
 // asynchronously deleted from the store.
 //
 // Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
 //   - domain: The instance domain (e.g., "mastodon.social")
 //
 // Returns:
 //   - *InstanceInfo: The cached info, or nil if not found/expired
 //   - err: if an error occurred, else nil
-func (c *cache) Get(domain string) (*InstanceInfo, error) {
+func (c *cache) Get(ctx context.Context, domain string) (*InstanceInfo, error) {
+	ctx, span := c.startSpan(ctx, "get", domain)
+	defer span.End()
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	info, err := c.store.Get(domain)
+	start := time.Now()
+	info, err := c.store.Get(ctx, domain)
+	c.observe(ctx, "get", start, err)
 	if err != nil || info == nil {
 		return nil, err
 	}
@@ -171,7 +295,8 @@ func (c *cache) Get(domain string) (*InstanceInfo, error) {
 	// Check if the entry has expired its ttl (not a big deal, the store shouldn't
 	// have allowed it, but we should tell it to delete it anyhow).
 	if time.Since(info.CachedAt) > c.ttl {
-		_ = c.store.Delete(domain)
+		start = time.Now()
+		c.observe(ctx, "delete", start, c.store.Delete(ctx, domain))
 	}
 
 	return info, nil
@@ -180,29 +305,139 @@ func (c *cache) Get(domain string) (*InstanceInfo, error) {
 // Set stores or updates instance info in the cache.
 //
 // Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
 //   - info: The instance info to cache. Must have Domain set.
 //
 // Returns:
 //   - error: Any storage error
-func (c *cache) Set(info *InstanceInfo) error {
+func (c *cache) Set(ctx context.Context, info *InstanceInfo) error {
+	ctx, span := c.startSpan(ctx, "set", info.Domain)
+	defer span.End()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return c.store.Set(info)
+	start := time.Now()
+	err := c.store.Set(ctx, info)
+	c.observe(ctx, "set", start, err)
+	return err
 }
 
 // Delete removes instance info from the cache.
 //
 // Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//   - domain: The instance domain to remove (e.g., "mastodon.social")
+//
+// Returns:
+//   - error: Any storage error (returns nil if domain wasn't cached)
+func (c *cache) Delete(ctx context.Context, domain string) error {
+	ctx, span := c.startSpan(ctx, "delete", domain)
+	defer span.End()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	err := c.store.Delete(ctx, domain)
+	c.observe(ctx, "delete", start, err)
+	return err
+}
+
+// GetMetadata retrieves cached instance metadata by domain.
+//
+// Expired entries (older than 24 hours) are treated as not found and
+// deleted from the store.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//   - domain: The instance domain (e.g., "mastodon.social")
+//
+// Returns:
+//   - *InstanceMetadata: The cached metadata, or nil if not found/expired
+//   - err: if an error occurred, else nil
+func (c *cache) GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error) {
+	ctx, span := c.startSpan(ctx, "get_metadata", domain)
+	defer span.End()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	start := time.Now()
+	meta, err := c.store.GetMetadata(ctx, domain)
+	c.observe(ctx, "get_metadata", start, err)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+
+	// Metadata goes stale much faster than the software name, so unlike Get
+	// we don't hand back expired entries.
+	if time.Since(meta.CachedAt) > c.metadataTTL {
+		start = time.Now()
+		c.observe(ctx, "delete_metadata", start, c.store.DeleteMetadata(ctx, domain))
+		return nil, nil
+	}
+
+	return meta, nil
+}
+
+// SetMetadata stores or updates instance metadata in the cache.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//   - meta: The metadata to cache. Must have Domain set.
+//
+// Returns:
+//   - error: Any storage error
+func (c *cache) SetMetadata(ctx context.Context, meta *InstanceMetadata) error {
+	ctx, span := c.startSpan(ctx, "set_metadata", meta.Domain)
+	defer span.End()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	err := c.store.SetMetadata(ctx, meta)
+	c.observe(ctx, "set_metadata", start, err)
+	return err
+}
+
+// DeleteMetadata removes instance metadata from the cache.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
 //   - domain: The instance domain to remove (e.g., "mastodon.social")
 //
 // Returns:
 //   - error: Any storage error (returns nil if domain wasn't cached)
-func (c *cache) Delete(domain string) error {
+func (c *cache) DeleteMetadata(ctx context.Context, domain string) error {
+	ctx, span := c.startSpan(ctx, "delete_metadata", domain)
+	defer span.End()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return c.store.Delete(domain)
+	start := time.Now()
+	err := c.store.DeleteMetadata(ctx, domain)
+	c.observe(ctx, "delete_metadata", start, err)
+	return err
+}
+
+// Ping checks that the underlying store is reachable.
+//
+// Parameters:
+//   - ctx: Request context, used for cancellation and log correlation
+//
+// Returns:
+//   - error: Any error reaching the store, nil if healthy
+func (c *cache) Ping(ctx context.Context) error {
+	ctx, span := c.startSpan(ctx, "ping", "")
+	defer span.End()
+
+	start := time.Now()
+	err := c.store.Ping(ctx)
+	c.observe(ctx, "ping", start, err)
+	return err
 }
 
 // Close closes the underlying database connection and releases resources.
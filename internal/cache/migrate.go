@@ -30,6 +30,16 @@ func desiredSchema(name string) *schema.Schema {
 					{Name: "domain", Type: &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 255}}},
 					{Name: "software", Type: &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 100}}},
 					{Name: "version", Type: &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 50}}},
+					{
+						Name:    "schema_version",
+						Type:    &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 10}},
+						Default: &schema.Literal{V: "''"},
+					},
+					{
+						Name:    "detector",
+						Type:    &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 50}},
+						Default: &schema.Literal{V: "''"},
+					},
 					{Name: "cached_at", Type: &schema.ColumnType{Type: &schema.TimeType{T: "timestamp"}}},
 				},
 				PrimaryKey: &schema.Index{
@@ -42,6 +52,23 @@ func desiredSchema(name string) *schema.Schema {
 					},
 				},
 			},
+			{
+				Name: "instance_metadata",
+				Columns: []*schema.Column{
+					{Name: "domain", Type: &schema.ColumnType{Type: &schema.StringType{T: "varchar", Size: 255}}},
+					{Name: "data", Type: &schema.ColumnType{Type: &schema.StringType{T: "text"}}},
+					{Name: "cached_at", Type: &schema.ColumnType{Type: &schema.TimeType{T: "timestamp"}}},
+				},
+				PrimaryKey: &schema.Index{
+					Parts: []*schema.IndexPart{{C: &schema.Column{Name: "domain"}}},
+				},
+				Indexes: []*schema.Index{
+					{
+						Name:  "idx_instance_metadata_cached_at",
+						Parts: []*schema.IndexPart{{C: &schema.Column{Name: "cached_at"}}},
+					},
+				},
+			},
 		},
 	}
 }
@@ -19,12 +19,21 @@ func init() {
 type mongoDBStore struct {
 	client     *mongo.Client
 	collection *mongo.Collection
+	metadata   *mongo.Collection
 }
 
 type mongoInstanceInfo struct {
+	Domain        string    `bson:"_id"`
+	Software      string    `bson:"software"`
+	Version       string    `bson:"version"`
+	SchemaVersion string    `bson:"schema_version"`
+	Detector      string    `bson:"detector"`
+	CachedAt      time.Time `bson:"cached_at"`
+}
+
+type mongoInstanceMetadata struct {
 	Domain   string    `bson:"_id"`
-	Software string    `bson:"software"`
-	Version  string    `bson:"version"`
+	Data     []byte    `bson:"data"`
 	CachedAt time.Time `bson:"cached_at"`
 }
 
@@ -50,14 +59,21 @@ func newMongoDBStore(dsn string) (Cache, error) {
 	}
 	_, _ = collection.Indexes().CreateOne(ctx, indexModel)
 
+	metadata := db.Collection("instance_metadata")
+	_, _ = metadata.Indexes().CreateOne(ctx, indexModel)
+
 	return &mongoDBStore{
 		client:     client,
 		collection: collection,
+		metadata:   metadata,
 	}, nil
 }
 
-func (s *mongoDBStore) Get(domain string) (*InstanceInfo, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *mongoDBStore) Get(ctx context.Context, domain string) (*InstanceInfo, error) {
+	ctx, span := startSpan(ctx, "mongodb", "get")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var doc mongoInstanceInfo
@@ -71,22 +87,29 @@ func (s *mongoDBStore) Get(domain string) (*InstanceInfo, error) {
 	}
 
 	return &InstanceInfo{
-		Domain:   doc.Domain,
-		Software: doc.Software,
-		Version:  doc.Version,
-		CachedAt: doc.CachedAt,
+		Domain:        doc.Domain,
+		Software:      doc.Software,
+		Version:       doc.Version,
+		SchemaVersion: doc.SchemaVersion,
+		Detector:      doc.Detector,
+		CachedAt:      doc.CachedAt,
 	}, nil
 }
 
-func (s *mongoDBStore) Set(info *InstanceInfo) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *mongoDBStore) Set(ctx context.Context, info *InstanceInfo) error {
+	ctx, span := startSpan(ctx, "mongodb", "set")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	doc := mongoInstanceInfo{
-		Domain:   info.Domain,
-		Software: info.Software,
-		Version:  info.Version,
-		CachedAt: info.CachedAt,
+		Domain:        info.Domain,
+		Software:      info.Software,
+		Version:       info.Version,
+		SchemaVersion: info.SchemaVersion,
+		Detector:      info.Detector,
+		CachedAt:      info.CachedAt,
 	}
 
 	opts := options.Replace().SetUpsert(true)
@@ -94,14 +117,79 @@ func (s *mongoDBStore) Set(info *InstanceInfo) error {
 	return err
 }
 
-func (s *mongoDBStore) Delete(domain string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *mongoDBStore) Delete(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "mongodb", "delete")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": domain})
 	return err
 }
 
+func (s *mongoDBStore) GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error) {
+	ctx, span := startSpan(ctx, "mongodb", "get_metadata")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var doc mongoInstanceMetadata
+	err := s.metadata.FindOne(ctx, bson.M{"_id": domain}).Decode(&doc)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstanceMetadata{
+		Domain:   doc.Domain,
+		Data:     doc.Data,
+		CachedAt: doc.CachedAt,
+	}, nil
+}
+
+func (s *mongoDBStore) SetMetadata(ctx context.Context, meta *InstanceMetadata) error {
+	ctx, span := startSpan(ctx, "mongodb", "set_metadata")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	doc := mongoInstanceMetadata{
+		Domain:   meta.Domain,
+		Data:     meta.Data,
+		CachedAt: meta.CachedAt,
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.metadata.ReplaceOne(ctx, bson.M{"_id": meta.Domain}, doc, opts)
+	return err
+}
+
+func (s *mongoDBStore) DeleteMetadata(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "mongodb", "delete_metadata")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.metadata.DeleteOne(ctx, bson.M{"_id": domain})
+	return err
+}
+
+func (s *mongoDBStore) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "mongodb", "ping")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return s.client.Ping(ctx, nil)
+}
+
 func (s *mongoDBStore) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
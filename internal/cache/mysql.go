@@ -7,6 +7,7 @@ import (
 	"context"
 	"database/sql"
 	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -57,12 +58,16 @@ func newMySQLStore(dsn string) (Cache, error) {
 	return &mySQLStore{db: db}, nil
 }
 
-func (s *mySQLStore) Get(domain string) (*InstanceInfo, error) {
+func (s *mySQLStore) Get(ctx context.Context, domain string) (*InstanceInfo, error) {
+	ctx, span := startSpan(ctx, "mysql", "get")
+	defer span.End()
+
 	var info InstanceInfo
-	err := s.db.QueryRow(
-		"SELECT domain, software, version, cached_at FROM instance_info WHERE domain = ?",
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, software, version, schema_version, detector, cached_at FROM instance_info WHERE domain = ?",
 		domain,
-	).Scan(&info.Domain, &info.Software, &info.Version, &info.CachedAt)
+	).Scan(&info.Domain, &info.Software, &info.Version, &info.SchemaVersion, &info.Detector, &info.CachedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -74,23 +79,83 @@ func (s *mySQLStore) Get(domain string) (*InstanceInfo, error) {
 	return &info, nil
 }
 
-func (s *mySQLStore) Set(info *InstanceInfo) error {
-	_, err := s.db.Exec(`
-		INSERT INTO instance_info (domain, software, version, cached_at)
-		VALUES (?, ?, ?, ?)
+func (s *mySQLStore) Set(ctx context.Context, info *InstanceInfo) error {
+	ctx, span := startSpan(ctx, "mysql", "set")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO instance_info (domain, software, version, schema_version, detector, cached_at)
+		VALUES (?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			software = VALUES(software),
 			version = VALUES(version),
+			schema_version = VALUES(schema_version),
+			detector = VALUES(detector),
+			cached_at = VALUES(cached_at)
+	`, info.Domain, info.Software, info.Version, info.SchemaVersion, info.Detector, info.CachedAt)
+	return err
+}
+
+func (s *mySQLStore) Delete(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "mysql", "delete")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_info WHERE domain = ?", domain)
+	return err
+}
+
+func (s *mySQLStore) GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error) {
+	ctx, span := startSpan(ctx, "mysql", "get_metadata")
+	defer span.End()
+
+	var meta InstanceMetadata
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, data, cached_at FROM instance_metadata WHERE domain = ?",
+		domain,
+	).Scan(&meta.Domain, &meta.Data, &meta.CachedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (s *mySQLStore) SetMetadata(ctx context.Context, meta *InstanceMetadata) error {
+	ctx, span := startSpan(ctx, "mysql", "set_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO instance_metadata (domain, data, cached_at)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			data = VALUES(data),
 			cached_at = VALUES(cached_at)
-	`, info.Domain, info.Software, info.Version, info.CachedAt)
+	`, meta.Domain, string(meta.Data), meta.CachedAt)
 	return err
 }
 
-func (s *mySQLStore) Delete(domain string) error {
-	_, err := s.db.Exec("DELETE FROM instance_info WHERE domain = ?", domain)
+func (s *mySQLStore) DeleteMetadata(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "mysql", "delete_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_metadata WHERE domain = ?", domain)
 	return err
 }
 
+func (s *mySQLStore) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "mysql", "ping")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
 func (s *mySQLStore) Close() error {
 	return s.db.Close()
 }
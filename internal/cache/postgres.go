@@ -6,6 +6,7 @@ package cache
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
@@ -36,12 +37,16 @@ func newPostgresStore(dsn string) (Cache, error) {
 	return &postgresStore{db: db}, nil
 }
 
-func (s *postgresStore) Get(domain string) (*InstanceInfo, error) {
+func (s *postgresStore) Get(ctx context.Context, domain string) (*InstanceInfo, error) {
+	ctx, span := startSpan(ctx, "postgresql", "get")
+	defer span.End()
+
 	var info InstanceInfo
-	err := s.db.QueryRow(
-		"SELECT domain, software, version, cached_at FROM instance_info WHERE domain = $1",
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, software, version, schema_version, detector, cached_at FROM instance_info WHERE domain = $1",
 		domain,
-	).Scan(&info.Domain, &info.Software, &info.Version, &info.CachedAt)
+	).Scan(&info.Domain, &info.Software, &info.Version, &info.SchemaVersion, &info.Detector, &info.CachedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -53,23 +58,83 @@ func (s *postgresStore) Get(domain string) (*InstanceInfo, error) {
 	return &info, nil
 }
 
-func (s *postgresStore) Set(info *InstanceInfo) error {
-	_, err := s.db.Exec(`
-		INSERT INTO instance_info (domain, software, version, cached_at)
-		VALUES ($1, $2, $3, $4)
+func (s *postgresStore) Set(ctx context.Context, info *InstanceInfo) error {
+	ctx, span := startSpan(ctx, "postgresql", "set")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO instance_info (domain, software, version, schema_version, detector, cached_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (domain) DO UPDATE SET
 			software = EXCLUDED.software,
 			version = EXCLUDED.version,
+			schema_version = EXCLUDED.schema_version,
+			detector = EXCLUDED.detector,
+			cached_at = EXCLUDED.cached_at
+	`, info.Domain, info.Software, info.Version, info.SchemaVersion, info.Detector, info.CachedAt)
+	return err
+}
+
+func (s *postgresStore) Delete(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "postgresql", "delete")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_info WHERE domain = $1", domain)
+	return err
+}
+
+func (s *postgresStore) GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error) {
+	ctx, span := startSpan(ctx, "postgresql", "get_metadata")
+	defer span.End()
+
+	var meta InstanceMetadata
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, data, cached_at FROM instance_metadata WHERE domain = $1",
+		domain,
+	).Scan(&meta.Domain, &meta.Data, &meta.CachedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (s *postgresStore) SetMetadata(ctx context.Context, meta *InstanceMetadata) error {
+	ctx, span := startSpan(ctx, "postgresql", "set_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO instance_metadata (domain, data, cached_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (domain) DO UPDATE SET
+			data = EXCLUDED.data,
 			cached_at = EXCLUDED.cached_at
-	`, info.Domain, info.Software, info.Version, info.CachedAt)
+	`, meta.Domain, string(meta.Data), meta.CachedAt)
 	return err
 }
 
-func (s *postgresStore) Delete(domain string) error {
-	_, err := s.db.Exec("DELETE FROM instance_info WHERE domain = $1", domain)
+func (s *postgresStore) DeleteMetadata(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "postgresql", "delete_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_metadata WHERE domain = $1", domain)
 	return err
 }
 
+func (s *postgresStore) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "postgresql", "ping")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
 func (s *postgresStore) Close() error {
 	return s.db.Close()
 }
@@ -6,6 +6,7 @@ package cache
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -36,12 +37,16 @@ func newSQLiteStore(path string) (Cache, error) {
 	return &sqliteStore{db: db}, nil
 }
 
-func (s *sqliteStore) Get(domain string) (*InstanceInfo, error) {
+func (s *sqliteStore) Get(ctx context.Context, domain string) (*InstanceInfo, error) {
+	ctx, span := startSpan(ctx, "sqlite", "get")
+	defer span.End()
+
 	var info InstanceInfo
-	err := s.db.QueryRow(
-		"SELECT domain, software, version, cached_at FROM instance_info WHERE domain = ?",
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, software, version, schema_version, detector, cached_at FROM instance_info WHERE domain = ?",
 		domain,
-	).Scan(&info.Domain, &info.Software, &info.Version, &info.CachedAt)
+	).Scan(&info.Domain, &info.Software, &info.Version, &info.SchemaVersion, &info.Detector, &info.CachedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -53,19 +58,74 @@ func (s *sqliteStore) Get(domain string) (*InstanceInfo, error) {
 	return &info, nil
 }
 
-func (s *sqliteStore) Set(info *InstanceInfo) error {
-	_, err := s.db.Exec(`
-		INSERT OR REPLACE INTO instance_info (domain, software, version, cached_at)
-		VALUES (?, ?, ?, ?)
-	`, info.Domain, info.Software, info.Version, info.CachedAt)
+func (s *sqliteStore) Set(ctx context.Context, info *InstanceInfo) error {
+	ctx, span := startSpan(ctx, "sqlite", "set")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO instance_info (domain, software, version, schema_version, detector, cached_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, info.Domain, info.Software, info.Version, info.SchemaVersion, info.Detector, info.CachedAt)
+	return err
+}
+
+func (s *sqliteStore) Delete(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "sqlite", "delete")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_info WHERE domain = ?", domain)
+	return err
+}
+
+func (s *sqliteStore) GetMetadata(ctx context.Context, domain string) (*InstanceMetadata, error) {
+	ctx, span := startSpan(ctx, "sqlite", "get_metadata")
+	defer span.End()
+
+	var meta InstanceMetadata
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT domain, data, cached_at FROM instance_metadata WHERE domain = ?",
+		domain,
+	).Scan(&meta.Domain, &meta.Data, &meta.CachedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (s *sqliteStore) SetMetadata(ctx context.Context, meta *InstanceMetadata) error {
+	ctx, span := startSpan(ctx, "sqlite", "set_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO instance_metadata (domain, data, cached_at)
+		VALUES (?, ?, ?)
+	`, meta.Domain, string(meta.Data), meta.CachedAt)
 	return err
 }
 
-func (s *sqliteStore) Delete(domain string) error {
-	_, err := s.db.Exec("DELETE FROM instance_info WHERE domain = ?", domain)
+func (s *sqliteStore) DeleteMetadata(ctx context.Context, domain string) error {
+	ctx, span := startSpan(ctx, "sqlite", "delete_metadata")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM instance_metadata WHERE domain = ?", domain)
 	return err
 }
 
+func (s *sqliteStore) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "sqlite", "ping")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
 func (s *sqliteStore) Close() error {
 	return s.db.Close()
 }
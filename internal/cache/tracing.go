@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("webap.to/internal/cache")
+
+// startSpan starts a client span for a single store operation.
+//
+// Parameters:
+//   - ctx: The parent context
+//   - system: The database system (e.g., "postgresql"), per the OpenTelemetry conventions
+//   - operation: The store operation (e.g., "get_metadata")
+//
+// Returns:
+//   - context.Context: ctx carrying the new span
+//   - trace.Span: The span, which the caller must End
+func startSpan(ctx context.Context, system, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNameKey.String(system),
+			semconv.DBOperationName(operation),
+		),
+	)
+}
+
+// startSpan starts a span for a cache wrapper operation, which covers locking
+// and expiry handling as well as the store operation itself.
+//
+// Parameters:
+//   - ctx: The parent context
+//   - operation: The cache operation (e.g., "get")
+//   - domain: The instance domain, or "" if the operation isn't about one
+//
+// Returns:
+//   - context.Context: ctx carrying the new span
+//   - trace.Span: The span, which the caller must End
+func (c *cache) startSpan(ctx context.Context, operation, domain string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("cache.backend", c.backend)}
+	if domain != "" {
+		attrs = append(attrs, attribute.String("webap.domain", domain))
+	}
+	return tracer.Start(ctx, "cache "+operation, trace.WithAttributes(attrs...))
+}
+
+// recordError marks the span in ctx as failed, if err is non-nil.
+func recordError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
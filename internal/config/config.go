@@ -4,8 +4,12 @@
 package config
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the server configuration loaded from environment variables/.env
@@ -14,14 +18,78 @@ import (
 //   - Port: HTTP server port (env: PORT, default: "9847")
 //   - Domain: Public domain name (env: DOMAIN, default: "localhost")
 //   - SiteName: Display name for the site (env: SITE_NAME, default: "WebAP.to")
-//   - StaticDir: Path to static files directory (env: STATIC_DIR, default: "static")
+//   - StaticDir: Serve static files from this directory instead of the embedded copy (env: STATIC_DIR, default: "" = embedded)
+//   - FrontendCDN: Load Lit and Open Props from their pinned CDN URLs instead of vendored copies (env: FRONTEND_CDN, default: true)
 //   - DatabaseURL: Database connection string (env: DATABASE_URL, default: "$DATA_DIR/webap_cache.db")
+//   - ShutdownTimeout: How long to drain in-flight requests on shutdown (env: SHUTDOWN_TIMEOUT, default: 15s)
+//   - ShutdownDelay: How long to keep serving, with /readyz failing, before draining on shutdown (env: SHUTDOWN_DELAY, default: 0s)
+//   - MetricsAddr: Separate listen address for /metrics, e.g. "127.0.0.1:9848" (env: METRICS_ADDR, default: "" = main port)
+//   - MetricsToken: Bearer token required to read /metrics (env: METRICS_TOKEN, default: "" = open)
+//   - CacheRequired: Refuse to start without a working cache (env: CACHE_REQUIRED, default: false)
+//   - LogFormat: Log output format, "text" or "json" (env: LOG_FORMAT, default: "text")
+//   - LogLevel: Minimum log level, "debug", "info", "warn" or "error" (env: LOG_LEVEL, default: "info")
+//   - OTLPEndpoint: OTLP/HTTP collector base URL for traces (env: OTEL_EXPORTER_OTLP_ENDPOINT, default: "" = tracing off)
+//   - RateLimitLookups: Software lookups per minute per client IP (env: RATE_LIMIT_LOOKUPS, default: 120, 0 = unlimited)
+//   - RateLimitFetches: Lookups that miss the cache per minute per client IP (env: RATE_LIMIT_FETCHES, default: 20, 0 = unlimited)
+//   - TrustedProxies: Comma separated proxy IPs/CIDRs whose X-Forwarded-For and X-Forwarded-Proto are believed (env: TRUSTED_PROXIES, default: "")
+//   - OutboundPerHost: Outbound requests per minute to any one remote host (env: OUTBOUND_PER_HOST, default: 30, 0 = unlimited)
+//   - OutboundConcurrency: Outbound requests in flight to any one remote host (env: OUTBOUND_CONCURRENCY, default: 2, 0 = unlimited)
+//   - CORSOrigins: Origins allowed to call /api/*, exact or with a "*" wildcard (env: CORS_ORIGINS, default: ["*"])
+//   - CORSMaxAge: How long browsers may cache preflight results (env: CORS_MAX_AGE, default: 1h)
+//   - CORSCredentials: Allow credentialed cross-origin requests (env: CORS_ALLOW_CREDENTIALS, default: false)
+//   - CSP: Content-Security-Policy for the landing page and assets (env: CSP, default: "" = built-in policy)
+//   - CSPHandle: Content-Security-Policy for the redirect page (env: CSP_HANDLE, default: "" = built-in policy)
+//   - HSTSMaxAge: Strict-Transport-Security max-age (env: HSTS_MAX_AGE, default: 1 year, 0 = off)
+//   - CookieSecret: Key the preferences cookie is signed with (env: COOKIE_SECRET, default: "" = generated into $DATA_DIR/cookie_secret)
+//   - DataDir: Directory for the SQLite database and ACME certificates (env: DATA_DIR, default: ".")
+//   - TLSPort: HTTPS port when TLS is enabled, PORT then only redirects (env: TLS_PORT, default: "443")
+//   - TLSAutocert: Get certificates for Domain from ACME/Let's Encrypt (env: TLS_AUTOCERT, default: false)
+//   - TLSCertFile: Certificate file, instead of autocert (env: TLS_CERT_FILE, default: "")
+//   - TLSKeyFile: Private key file, instead of autocert (env: TLS_KEY_FILE, default: "")
+//   - ACMEEmail: Contact address given to the ACME CA (env: ACME_EMAIL, default: "")
+//   - ACMEDirectory: ACME directory URL (env: ACME_DIRECTORY, default: "" = Let's Encrypt production)
+//   - ACMECARoot: PEM file of extra root CAs to trust when talking to the ACME directory (env: ACME_CA_ROOT, default: "" = system roots)
 type Config struct {
-	Port        string
-	Domain      string
-	SiteName    string
-	StaticDir   string
-	DatabaseURL string
+	Port            string
+	Domain          string
+	SiteName        string
+	StaticDir       string
+	FrontendCDN     bool
+	DatabaseURL     string
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration
+	MetricsAddr     string
+	MetricsToken    string
+	CacheRequired   bool
+	LogFormat       string
+	LogLevel        string
+	OTLPEndpoint    string
+
+	RateLimitLookups int
+	RateLimitFetches int
+	TrustedProxies   string
+
+	OutboundPerHost     int
+	OutboundConcurrency int
+
+	CORSOrigins     []string
+	CORSMaxAge      time.Duration
+	CORSCredentials bool
+
+	CSP        string
+	CSPHandle  string
+	HSTSMaxAge time.Duration
+
+	CookieSecret string
+
+	DataDir       string
+	TLSPort       string
+	TLSAutocert   bool
+	TLSCertFile   string
+	TLSKeyFile    string
+	ACMEEmail     string
+	ACMEDirectory string
+	ACMECARoot    string
 }
 
 // Load reads configuration from environment variables with sensible defaults.
@@ -30,9 +98,36 @@ type Config struct {
 //   - PORT: HTTP server port (default: "9847")
 //   - DOMAIN: Public domain name (default: "localhost")
 //   - SITE_NAME: Display name for the site (default: "WebAP.to")
-//   - STATIC_DIR: Path to static files (default: "static")
+//   - STATIC_DIR: Serve static files from disk, with live reload, for frontend development (default: "" = embedded in the binary)
+//   - FRONTEND_CDN: Load third-party frontend libraries from their pinned CDN URLs; "false" serves them from static/vendor/, which has to have them (default: "true")
 //   - DATABASE_URL: Full database connection string (overrides DATA_DIR)
 //   - DATA_DIR: Directory for SQLite database (default: ".", creates webap_cache.db)
+//   - SHUTDOWN_TIMEOUT: Graceful shutdown drain timeout, as a Go duration (default: "15s")
+//   - SHUTDOWN_DELAY: Time to keep serving with /readyz failing before draining, as a Go duration, so load balancers can stop sending traffic (default: "0s")
+//   - METRICS_ADDR: Serve /metrics on this address instead of the main port (default: "")
+//   - METRICS_TOKEN: Require "Authorization: Bearer {token}" for /metrics (default: "")
+//   - CACHE_REQUIRED: Make cache initialisation failure fatal instead of running uncached (default: "false")
+//   - LOG_FORMAT: "text" for logfmt-style lines, "json" for JSON lines (default: "text")
+//   - LOG_LEVEL: Minimum level to log: debug, info, warn or error (default: "info")
+//   - OTEL_EXPORTER_OTLP_ENDPOINT: Export traces to this OTLP/HTTP collector, e.g. "http://localhost:4318" (default: "")
+//   - RATE_LIMIT_LOOKUPS: /api/software requests per minute per client IP, 0 to disable (default: "120")
+//   - RATE_LIMIT_FETCHES: /api/software cache misses per minute per client IP, 0 to disable (default: "20")
+//   - TRUSTED_PROXIES: Reverse proxies to take X-Forwarded-For and X-Forwarded-Proto from, e.g. "127.0.0.1,10.0.0.0/8" (default: "")
+//   - OUTBOUND_PER_HOST: Requests per minute we'll make to any one remote host, 0 to disable (default: "30")
+//   - OUTBOUND_CONCURRENCY: Concurrent requests we'll make to any one remote host, 0 to disable (default: "2")
+//   - CORS_ORIGINS: Comma separated origins allowed to call the API, e.g. "https://example.com,https://*.example.org" (default: "*")
+//   - CORS_MAX_AGE: Preflight cache lifetime, as a Go duration (default: "1h")
+//   - CORS_ALLOW_CREDENTIALS: Send Access-Control-Allow-Credentials (ignored with CORS_ORIGINS=*) (default: "false")
+//   - CSP: Override the Content-Security-Policy for the landing page and assets (default: built-in)
+//   - CSP_HANDLE: Override the Content-Security-Policy for handle.html (default: built-in)
+//   - HSTS_MAX_AGE: Strict-Transport-Security max-age as a Go duration, "0" to disable (default: "8760h")
+//   - COOKIE_SECRET: Secret for signing the preferences cookie, shared by every replica (default: "" = random, saved to $DATA_DIR/cookie_secret)
+//   - TLS_PORT: HTTPS port, used when TLS_AUTOCERT or TLS_CERT_FILE/TLS_KEY_FILE are set (default: "443")
+//   - TLS_AUTOCERT: Get a certificate for DOMAIN via ACME, stored in $DATA_DIR/autocert (default: "false")
+//   - TLS_CERT_FILE, TLS_KEY_FILE: Serve HTTPS with this certificate and key, reloaded when they change (default: "")
+//   - ACME_EMAIL: Contact email for the ACME account (default: "")
+//   - ACME_DIRECTORY: ACME directory URL, e.g. a local Pebble for testing (default: Let's Encrypt)
+//   - ACME_CA_ROOT: PEM file with the ACME directory's root CA, e.g. Pebble's, trusted on top of the system roots (default: "")
 //
 // Returns:
 //   - *Config: Populated configuration struct
@@ -41,21 +136,114 @@ func Load() *Config {
 		Port:      getEnv("PORT", "9847"),
 		Domain:    getEnv("DOMAIN", "localhost"),
 		SiteName:  getEnv("SITE_NAME", "WebAP.to"),
-		StaticDir: getEnv("STATIC_DIR", "static"),
+		StaticDir: os.Getenv("STATIC_DIR"),
+
+		FrontendCDN: getEnvBool("FRONTEND_CDN", true),
+
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		ShutdownDelay:   getEnvDuration("SHUTDOWN_DELAY", 0),
+		MetricsAddr:     os.Getenv("METRICS_ADDR"),
+		MetricsToken:    os.Getenv("METRICS_TOKEN"),
+		CacheRequired:   getEnvBool("CACHE_REQUIRED", false),
+		LogFormat:       getEnv("LOG_FORMAT", "text"),
+		LogLevel:        getEnv("LOG_LEVEL", "info"),
+		OTLPEndpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+
+		RateLimitLookups: getEnvInt("RATE_LIMIT_LOOKUPS", 120),
+		RateLimitFetches: getEnvInt("RATE_LIMIT_FETCHES", 20),
+		TrustedProxies:   os.Getenv("TRUSTED_PROXIES"),
+
+		OutboundPerHost:     getEnvInt("OUTBOUND_PER_HOST", 30),
+		OutboundConcurrency: getEnvInt("OUTBOUND_CONCURRENCY", 2),
+
+		CORSOrigins:     getEnvList("CORS_ORIGINS", []string{"*"}),
+		CORSMaxAge:      getEnvDuration("CORS_MAX_AGE", time.Hour),
+		CORSCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+
+		CSP:        os.Getenv("CSP"),
+		CSPHandle:  os.Getenv("CSP_HANDLE"),
+		HSTSMaxAge: getEnvDuration("HSTS_MAX_AGE", 365*24*time.Hour),
+
+		CookieSecret: os.Getenv("COOKIE_SECRET"),
+
+		DataDir:       getEnv("DATA_DIR", "."),
+		TLSPort:       getEnv("TLS_PORT", "443"),
+		TLSAutocert:   getEnvBool("TLS_AUTOCERT", false),
+		TLSCertFile:   os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:    os.Getenv("TLS_KEY_FILE"),
+		ACMEEmail:     os.Getenv("ACME_EMAIL"),
+		ACMEDirectory: os.Getenv("ACME_DIRECTORY"),
+		ACMECARoot:    os.Getenv("ACME_CA_ROOT"),
 	}
 
 	cfg.DatabaseURL = os.Getenv("DATABASE_URL")
 	if cfg.DatabaseURL == "" {
-		dataDir := getEnv("DATA_DIR", ".")
-		cfg.DatabaseURL = filepath.Join(dataDir, "webap_cache.db")
+		cfg.DatabaseURL = filepath.Join(cfg.DataDir, "webap_cache.db")
 	}
 
 	return cfg
 }
 
+// TLSEnabled reports whether the server should serve HTTPS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSAutocert || c.TLSCertFile != "" || c.TLSKeyFile != ""
+}
+
 func getEnv(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
 	}
 	return fallback
 }
+
+func getEnvList(key string, fallback []string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return fallback
+	}
+	return list
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		slog.Warn("invalid config value, using default", "key", key, "value", val, "default", fallback, "error", err)
+		return fallback
+	}
+	return d
+}
+
+func getEnvInt(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		slog.Warn("invalid config value, using default", "key", key, "value", val, "default", fallback, "error", err)
+		return fallback
+	}
+	return n
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		slog.Warn("invalid config value, using default", "key", key, "value", val, "default", fallback, "error", err)
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package metrics holds the Prometheus collectors exported on /metrics.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "webap"
+
+// Registry is the registry all WebAP.to collectors are registered with.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts handled requests by route pattern, method and status code.
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	// HTTPDuration observes request latency by route pattern and method.
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	// SoftwareLookups counts /api/software lookups by cache result (hit, miss, stale).
+	SoftwareLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "software",
+		Name:      "lookups_total",
+		Help:      "Software lookups, by cache result (hit, miss, stale).",
+	}, []string{"result"})
+
+	// NodeInfoFetches counts nodeinfo fetches by outcome (ok or an error class).
+	NodeInfoFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "nodeinfo",
+		Name:      "fetches_total",
+		Help:      "Nodeinfo fetches, by outcome (ok or error class).",
+	}, []string{"outcome"})
+
+	// NodeInfoDuration observes nodeinfo fetch duration by outcome.
+	NodeInfoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "nodeinfo",
+		Name:      "fetch_duration_seconds",
+		Help:      "Nodeinfo fetch duration, by outcome.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"outcome"})
+
+	// OutboundInFlight tracks outbound HTTP requests to remote instances.
+	OutboundInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "outbound",
+		Name:      "requests_in_flight",
+		Help:      "Outbound HTTP requests to remote instances currently in flight.",
+	})
+
+	// CacheDuration observes cache operation latency by backend and operation.
+	CacheDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "operation_duration_seconds",
+		Help:      "Cache operation latency, by backend and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"backend", "operation"})
+
+	// Redirects counts redirect pages served by the (cached) software of the
+	// target, as api.SoftwareLabel.
+	Redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Redirect pages served, by target instance software (unknown if not cached, other if not one we know).",
+	}, []string{"software"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		SoftwareLookups,
+		NodeInfoFetches,
+		NodeInfoDuration,
+		OutboundInFlight,
+		CacheDuration,
+		Redirects,
+	)
+}
+
+// Handler returns the /metrics handler.
+//
+// Parameters:
+//   - token: If non-empty, requests must carry "Authorization: Bearer {token}"
+//
+// Returns:
+//   - http.Handler: The Prometheus exposition handler
+func Handler(token string) http.Handler {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+	if token == "" {
+		return h
+	}
+
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Middleware records request counts and latency for every request passing
+// through next. Routes are labelled by the ServeMux pattern that matched, so
+// arbitrary paths don't blow up label cardinality.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		HTTPDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// InstrumentTransport wraps an outbound transport so in-flight requests are
+// tracked by OutboundInFlight.
+func InstrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	return promhttp.InstrumentRoundTripperInFlight(OutboundInFlight, rt)
+}
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package ratelimit provides per-client token bucket rate limiting.
+//
+// Lookups are limited in two tiers: every request spends a token from the
+// client's lookup bucket in Middleware, and requests that go on to make
+// outbound requests (cache misses) also spend one from the client's fetch
+// bucket via AllowFetch. Cache hits are cheap, so the fetch bucket can be much
+// smaller without hurting well-behaved clients.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long a client's buckets are kept after its last request.
+// By then they'd have refilled anyway, so forgetting them loses nothing.
+const idleTimeout = 10 * time.Minute
+
+// Error is returned when a client has run out of tokens.
+type Error struct {
+	// RetryAfter is how long until the client will have a token again.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// RetryAfterSeconds returns RetryAfter rounded up to whole seconds, as used
+// by the Retry-After header.
+func (e *Error) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Limiter holds a token bucket per client key.
+type Limiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiter creates a limiter allowing each client perMinute requests a
+// minute. Buckets hold a minute's worth of tokens, so short bursts are fine.
+//
+// Parameters:
+//   - perMinute: Sustained requests per minute per client. Zero or less disables limiting.
+//
+// Returns:
+//   - *Limiter: The limiter, or nil if limiting is disabled
+func NewLimiter(perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{
+		limit:   rate.Limit(float64(perMinute) / 60),
+		burst:   perMinute,
+		clients: map[string]*bucket{},
+	}
+}
+
+// Allow spends a token from key's bucket.
+//
+// A nil Limiter allows everything.
+//
+// Parameters:
+//   - key: The client key (typically its IP address)
+//
+// Returns:
+//   - error: *Error if the bucket is empty, else nil
+func (l *Limiter) Allow(key string) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.clients {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.clients[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return &Error{RetryAfter: delay}
+	}
+	return nil
+}
+
+type fetchKey struct{}
+
+type fetchBudget struct {
+	limiter *Limiter
+	client  string
+}
+
+// AllowFetch spends a token from the fetch bucket of the client that made the
+// request in ctx. Call it before making outbound requests on a client's behalf.
+//
+// Requests that didn't pass through Middleware (or when fetch limiting is
+// disabled) are always allowed.
+//
+// Parameters:
+//   - ctx: The request context
+//
+// Returns:
+//   - error: *Error if the client has run out of fetches, else nil
+func AllowFetch(ctx context.Context) error {
+	budget, ok := ctx.Value(fetchKey{}).(*fetchBudget)
+	if !ok {
+		return nil
+	}
+	return budget.limiter.Allow(budget.client)
+}
+
+// Middleware limits requests per client IP, or per /64 for IPv6 clients (see
+// ClientKey).
+//
+// Every request spends a lookup token; requests without one get a 429 with a
+// Retry-After header. The fetch limiter is attached to the request context for
+// AllowFetch.
+//
+// Parameters:
+//   - lookups: Limiter for all requests (nil for unlimited)
+//   - fetches: Limiter for requests that trigger outbound fetches (nil for unlimited)
+//   - trustedProxies: Proxies whose X-Forwarded-For header is believed
+//
+// Returns:
+//   - func(http.Handler) http.Handler: The middleware
+func Middleware(lookups, fetches *Limiter, trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Preflights are free, they never touch the cache or the network.
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ClientKey(ClientIP(r, trustedProxies))
+
+			if err := lookups.Allow(client); err != nil {
+				WriteError(w, err.(*Error))
+				return
+			}
+
+			if fetches != nil {
+				ctx := context.WithValue(r.Context(), fetchKey{}, &fetchBudget{fetches, client})
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteError writes a 429 response for a rate limit error.
+//
+// Parameters:
+//   - w: The response writer
+//   - err: The rate limit error
+func WriteError(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Retry-After", strconv.Itoa(err.RetryAfterSeconds()))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
+
+// ClientIP works out the IP address of the client that made r.
+//
+// X-Forwarded-For is only believed when the request came from a trusted proxy,
+// and is walked from the right (the entry our proxy added) skipping further
+// trusted proxies, so clients can't spoof their way out of a limit by sending
+// their own header.
+//
+// Parameters:
+//   - r: The request
+//   - trustedProxies: Proxy address ranges to trust
+//
+// Returns:
+//   - string: The client IP, or the raw RemoteAddr if it can't be parsed
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	remote = remote.Unmap()
+
+	if !trusted(remote, trustedProxies) {
+		return remote.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !trusted(addr, trustedProxies) {
+			return addr.String()
+		}
+		remote = addr
+	}
+	return remote.String()
+}
+
+// FromTrustedProxy reports whether r came straight from a trusted proxy, so
+// the X-Forwarded-* headers it sets can be believed.
+//
+// Parameters:
+//   - r: The request
+//   - trustedProxies: Proxy address ranges to trust
+//
+// Returns:
+//   - bool: Whether r's RemoteAddr is in trustedProxies
+func FromTrustedProxy(r *http.Request, trustedProxies []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	return trusted(remote.Unmap(), trustedProxies)
+}
+
+// ClientKey returns the rate limit bucket for a client IP. IPv6 clients are
+// keyed on their /64, as that's what a single subscriber usually gets, and
+// rotating through it would otherwise give them a fresh bucket per request.
+//
+// Parameters:
+//   - ip: The client IP, as from ClientIP
+//
+// Returns:
+//   - string: The IPv4 address, the IPv6 /64 prefix (e.g., "2001:db8:1:2::/64"), or ip as is if it can't be parsed
+func ClientKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	if addr = addr.Unmap(); addr.Is4() {
+		return addr.String()
+	}
+	prefix, err := addr.WithZone("").Prefix(64)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+func trusted(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProxies parses a comma separated list of IP addresses and CIDR ranges.
+//
+// Parameters:
+//   - list: e.g. "10.0.0.0/8, 192.168.1.1, fd00::/8"
+//
+// Returns:
+//   - []netip.Prefix: The parsed ranges (single addresses become /32 or /128)
+//   - error: The first entry that couldn't be parsed
+func ParseProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			p, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+
+	"webap.to/internal/assets"
+)
+
+// minGzipSize is the smallest response worth gzipping on the fly. Most single
+// lookups come in under it; batch responses usually don't.
+const minGzipSize = 1024
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+// gzipJSON gzips JSON responses on the fly for clients that accept it.
+// Static assets don't need this, they come precompressed from the asset
+// pipeline.
+func gzipJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !assets.AcceptsEncoding(r, "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// it's worth compressing: it has to be JSON, not already encoded, and at least
+// minGzipSize bytes.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	g.status = status
+
+	h := g.Header()
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || strings.TrimSpace(mediaType) != "application/json" {
+		g.decided = true
+		g.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.decided {
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= minGzipSize {
+		if err := g.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// startGzip commits to a compressed response and flushes the buffer into it.
+func (g *gzipResponseWriter) startGzip() error {
+	g.decided = true
+	h := g.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+
+	g.gz = gzipWriters.Get().(*gzip.Writer)
+	g.gz.Reset(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// finish writes out whatever is still buffered, uncompressed if it never got
+// big enough.
+func (g *gzipResponseWriter) finish() {
+	if g.gz != nil {
+		_ = g.gz.Close()
+		gzipWriters.Put(g.gz)
+		return
+	}
+	if !g.wroteHeader {
+		// Nothing was written, let the server send its default response.
+		return
+	}
+	if !g.decided {
+		g.ResponseWriter.WriteHeader(g.status)
+		_, _ = g.ResponseWriter.Write(g.buf)
+	}
+}
+
+// Flush sends everything so far, committing to compression if undecided.
+func (g *gzipResponseWriter) Flush() {
+	if g.wroteHeader && !g.decided {
+		_ = g.startGzip()
+	}
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"webap.to/internal/config"
+)
+
+// corsPolicy decides which cross-origin callers may use the API.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     []string // exact origins, lowercased
+	patterns    []string // origins with a "*" wildcard, lowercased
+	maxAge      string
+	credentials bool
+}
+
+// newCORSPolicy builds the CORS policy from the configuration.
+//
+// Origins may be exact (e.g., "https://example.com"), contain a wildcard
+// (e.g., "https://*.example.com") or be "*" for any origin. Credentials can't
+// be combined with "*", as that would let any site make credentialed
+// requests, so the credentials flag is ignored (with a warning) in that case.
+func newCORSPolicy(cfg *config.Config) *corsPolicy {
+	p := &corsPolicy{
+		maxAge:      strconv.Itoa(int(cfg.CORSMaxAge / time.Second)),
+		credentials: cfg.CORSCredentials,
+	}
+
+	for _, origin := range cfg.CORSOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			p.patterns = append(p.patterns, origin)
+		default:
+			p.origins = append(p.origins, origin)
+		}
+	}
+
+	if p.anyOrigin && p.credentials {
+		slog.Warn("CORS_ALLOW_CREDENTIALS ignored, it can't be combined with CORS_ORIGINS=*")
+		p.credentials = false
+	}
+
+	return p
+}
+
+// allowed reports whether origin may make cross-origin requests.
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if slices.Contains(p.origins, origin) {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// handler wraps an API route with the CORS policy. Every /api/* route and
+// /oembed goes through it.
+//
+// Preflight (and any other OPTIONS) requests are answered here and never reach
+// next. Disallowed origins simply get no CORS headers, so browsers block them.
+//
+// Parameters:
+//   - methods: The methods the route accepts, for Access-Control-Allow-Methods (e.g., "GET")
+//   - next: The route's handler
+//
+// Returns:
+//   - http.Handler: The wrapped handler
+func (p *corsPolicy) handler(methods string, next http.Handler) http.Handler {
+	allowMethods := methods + ", OPTIONS"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && p.allowed(origin)
+		if allowed {
+			if p.anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.credentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method != http.MethodOptions {
+			if allowed {
+				h.Set("Access-Control-Expose-Headers", "Retry-After, X-Request-ID")
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Allow", allowMethods)
+		if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", allowMethods)
+			h.Set("Access-Control-Allow-Headers", "Content-Type")
+			h.Set("Access-Control-Max-Age", p.maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
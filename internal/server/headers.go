@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"webap.to/internal/assets"
+	"webap.to/internal/config"
+)
+
+// Default Content-Security-Policies, used unless overridden by CSP/CSP_HANDLE.
+// The %s verbs take the extra script-src and style-src sources the asset
+// pipeline needs: the import map's hash, plus the third-party libraries' CDNs
+// when FRONTEND_CDN is set.
+const (
+	// defaultPageCSP covers the landing page and static assets. Fonts come from
+	// Google Fonts.
+	defaultPageCSP = "default-src 'self'; " +
+		"script-src 'self'%s; " +
+		"style-src 'self' 'unsafe-inline'%s https://fonts.googleapis.com; " +
+		"font-src 'self' https://fonts.gstatic.com; " +
+		"img-src 'self' data:; " +
+		"connect-src 'self'; " +
+		"manifest-src 'self'; " +
+		"worker-src 'self'; " +
+		"object-src 'none'; " +
+		"base-uri 'none'; " +
+		"form-action 'self'; " +
+		"frame-ancestors 'none'"
+
+	// defaultHandleCSP covers the redirect page. It only ever needs our own
+	// images and API, and only submits forms back to us (picking a home
+	// instance without JavaScript).
+	defaultHandleCSP = "default-src 'none'; " +
+		"script-src 'self'%s; " +
+		"style-src 'self' 'unsafe-inline'%s https://fonts.googleapis.com; " +
+		"font-src https://fonts.gstatic.com; " +
+		"img-src 'self'; " +
+		"connect-src 'self'; " +
+		"manifest-src 'self'; " +
+		"object-src 'none'; " +
+		"base-uri 'none'; " +
+		"form-action 'self'; " +
+		"frame-ancestors 'none'"
+
+	// apiCSP covers JSON responses, which should never be rendered as a page.
+	apiCSP = "default-src 'none'; frame-ancestors 'none'"
+)
+
+// securityPolicy is the set of security headers that varies per route.
+type securityPolicy struct {
+	CSP            string
+	ReferrerPolicy string
+}
+
+// apply sets the policy's headers on w, replacing any set by an outer policy.
+func (p securityPolicy) apply(w http.ResponseWriter) {
+	h := w.Header()
+	if p.CSP != "" {
+		h.Set("Content-Security-Policy", p.CSP)
+	}
+	h.Set("Referrer-Policy", p.ReferrerPolicy)
+}
+
+// wrap returns next with the policy applied to its responses.
+func (p securityPolicy) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.apply(w)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// securityPolicies are the policies for each kind of route. Every response
+// gets the page policy, and routes with their own replace it.
+type securityPolicies struct {
+	// page covers the landing page and static assets, and is the default.
+	page securityPolicy
+	// handle covers handle.html, which does the redirecting. It doesn't leak
+	// the target in the Referer to wherever it sends the user.
+	handle securityPolicy
+	// api covers /api/* JSON responses.
+	api securityPolicy
+}
+
+// newSecurityPolicies builds the per-route policies. Custom CSP/CSP_HANDLE
+// values are used as-is, so they need to allow the import map themselves.
+func newSecurityPolicies(cfg *config.Config, static *assets.Pipeline) securityPolicies {
+	scripts := cspSources(static.ScriptSources())
+	styles := cspSources(static.StyleSources())
+
+	p := securityPolicies{
+		page:   securityPolicy{CSP: fmt.Sprintf(defaultPageCSP, scripts, styles), ReferrerPolicy: "strict-origin-when-cross-origin"},
+		handle: securityPolicy{CSP: fmt.Sprintf(defaultHandleCSP, scripts, styles), ReferrerPolicy: "no-referrer"},
+		api:    securityPolicy{CSP: apiCSP, ReferrerPolicy: "no-referrer"},
+	}
+	if cfg.CSP != "" {
+		p.page.CSP = cfg.CSP
+	}
+	if cfg.CSPHandle != "" {
+		p.handle.CSP = cfg.CSPHandle
+	}
+	return p
+}
+
+// cspSources formats extra CSP sources to follow an existing one.
+func cspSources(sources []string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	return " " + strings.Join(sources, " ")
+}
+
+// securityHeaders sets the headers every response gets, then applies the
+// default policy. Routes wanting a different policy apply it themselves,
+// which overrides the default.
+//
+// Parameters:
+//   - hsts: Strict-Transport-Security max-age, 0 to leave the header out
+//   - def: The default per-route policy
+//   - next: The handler to wrap
+//
+// Returns:
+//   - http.Handler: The wrapped handler
+func securityHeaders(hsts time.Duration, def securityPolicy, next http.Handler) http.Handler {
+	hstsValue := "max-age=" + strconv.Itoa(int(hsts/time.Second))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=(), usb=(), interest-cohort=()")
+		h.Set("Cross-Origin-Opener-Policy", "same-origin")
+		// Browsers ignore HSTS over plain HTTP, so it's safe to always send.
+		if hsts > 0 {
+			h.Set("Strict-Transport-Security", hstsValue)
+		}
+		def.apply(w)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Cache  string `json:"cache,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthzHandler is the liveness probe. It only reports that the process is
+// up and serving HTTP.
+//
+// Response (200 OK):
+//
+//	{"status": "ok"}
+func (s *Server) healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyzHandler is the readiness probe. It pings the active cache store.
+//
+// Running without a cache is reported as "degraded". That is still ready
+// (200) unless the cache is required, in which case it's 503. The server is
+// never ready once it has been told to shut down (see Drain).
+//
+// Response (200 OK):
+//
+//	{"status": "ok", "cache": "ok"}
+//
+// Response (200 OK or 503 Service Unavailable):
+//
+//	{"status": "degraded", "cache": "unavailable", "error": "dial tcp 127.0.0.1:5432: connect: connection refused"}
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: "shutting_down"})
+		return
+	}
+
+	resp := healthResponse{Status: "ok", Cache: "ok"}
+	if s.cache == nil {
+		resp = healthResponse{Status: "degraded", Cache: "disabled", Error: "cache failed to initialize"}
+	} else if err := s.cache.Ping(r.Context()); err != nil {
+		resp = healthResponse{Status: "degraded", Cache: "unavailable", Error: err.Error()}
+	}
+
+	code := http.StatusOK
+	if resp.Status != "ok" && s.config.CacheRequired {
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, resp)
+}
+
+func writeHealth(w http.ResponseWriter, code int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
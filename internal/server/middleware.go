@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"webap.to/internal/logging"
+)
+
+// maxRequestIDLength bounds incoming X-Request-ID values we're willing to reuse.
+const maxRequestIDLength = 128
+
+// requestLogger assigns each request an ID and writes an access log line once
+// it has been handled.
+//
+// An incoming X-Request-ID is reused if it looks sane, so IDs assigned by a
+// reverse proxy carry through. Either way the ID is echoed back in the
+// X-Request-ID response header and attached to the request context, so every
+// log line written while handling the request can be correlated.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		r = r.WithContext(ctx)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		slog.InfoContext(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		)
+	})
+}
+
+// validRequestID reports whether id is short and only contains printable,
+// non-space ASCII, so it's safe to echo back and write to logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"webap.to/internal/api"
+)
+
+const (
+	// Default and smallest sizes of the embed card, in pixels. Consumers can
+	// shrink it with maxwidth/maxheight, down to the minimums; asking for less
+	// gets a 501, as the spec says.
+	oembedWidth     = 550
+	oembedHeight    = 240
+	oembedMinWidth  = 200
+	oembedMinHeight = 120
+
+	// oembedCacheAge is how long consumers may keep an embed, in seconds. It
+	// matches how long we keep the preview it's built from.
+	oembedCacheAge = 3600
+
+	// oembedRetryAge is how long consumers may keep an embed built from only
+	// the basics or the instance's metadata, in seconds, so they come back for
+	// the full preview soon.
+	oembedRetryAge = 60
+)
+
+// errOEmbedTooSmall is returned for a maxwidth or maxheight below the card's
+// minimum size.
+var errOEmbedTooSmall = errors.New("too small for the embed")
+
+// oembedResponse is an oEmbed "rich" response (https://oembed.com).
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	CacheAge     int    `json:"cache_age"`
+}
+
+// oembedCard is the embed's HTML: a plain blockquote and link, which is about
+// all WordPress and friends let through for providers they don't know, styled
+// inline for the ones that let more through.
+var oembedCard = template.Must(template.New("oembed").Parse(
+	`<blockquote class="webap-embed" cite="{{.PageURL}}" style="box-sizing:border-box;max-width:{{.Width}}px;margin:1em 0;padding:1em 1.25em;border:1px solid #d4d4e8;border-left:4px solid #6366f1;border-radius:8px;font-family:system-ui,sans-serif;line-height:1.4">` +
+		`<p style="margin:0 0 .5em;font-weight:600">{{.Preview.Title}}</p>` +
+		`{{if .Preview.Description}}<p style="margin:0 0 .75em">{{.Preview.Description}}</p>{{end}}` +
+		`{{if .Preview.LargeImage}}<img src="{{.Preview.Image}}" alt="{{.Preview.ImageAlt}}" style="display:block;max-width:100%;max-height:{{.Height}}px;margin:0 0 .75em;border-radius:4px">{{end}}` +
+		`<p style="margin:0"><a href="{{.PageURL}}" target="_blank" rel="noopener" style="font-weight:600;color:#6366f1">Open on your instance</a>` +
+		` <span style="color:#6b7280">· {{.Preview.SiteName}} via {{.SiteName}}</span></p>` +
+		`</blockquote>`))
+
+// oembedHandler handles GET /oembed, the oEmbed provider for our redirect
+// pages, so CMSs like WordPress and Ghost can embed web+ap links as a card
+// with an "Open on your instance" button. handle.html advertises it with a
+// discovery <link>.
+//
+// Query Parameters:
+//   - url: A redirect page URL on this site (e.g., "https://webap.to/pixelfed.social/p/abc123"), or a web+ap:// URL
+//   - maxwidth, maxheight: Largest size the consumer has room for (optional)
+//   - format: "json" (optional, the only format supported)
+//
+// Response (200 OK):
+//
+//	{
+//	  "version": "1.0",
+//	  "type": "rich",
+//	  "html": "<blockquote class=\"webap-embed\" ...>...</blockquote>",
+//	  "width": 550,
+//	  "height": 240,
+//	  "title": "Jane (@jane@pixelfed.social)",
+//	  "author_name": "@jane@pixelfed.social",
+//	  "author_url": "https://webap.to/@jane@pixelfed.social",
+//	  "provider_name": "WebAP.to",
+//	  "provider_url": "https://webap.to/",
+//	  "cache_age": 3600
+//	}
+//
+// The cache_age (and Cache-Control) drops to a minute when the preview isn't
+// complete yet, e.g. the target's instance was slow or wouldn't hand it over.
+//
+// Errors:
+//   - 400 Bad Request: Missing url, or invalid maxwidth/maxheight
+//   - 404 Not Found: url isn't one of our redirect pages
+//   - 501 Not Implemented: A format other than json, or maxwidth/maxheight below 200x120
+func (s *Server) oembedHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "json" {
+		http.Error(w, "Only the json format is supported", http.StatusNotImplemented)
+		return
+	}
+
+	raw := query.Get("url")
+	if raw == "" {
+		http.Error(w, "Missing url parameter", http.StatusBadRequest)
+		return
+	}
+	width, err := oembedSize(query.Get("maxwidth"), oembedWidth, oembedMinWidth)
+	if err != nil {
+		oembedSizeError(w, "maxwidth", err)
+		return
+	}
+	height, err := oembedSize(query.Get("maxheight"), oembedHeight, oembedMinHeight)
+	if err != nil {
+		oembedSizeError(w, "maxheight", err)
+		return
+	}
+
+	origin := s.publicOrigin(r)
+	target, pageURL, ok := s.oembedTarget(raw, origin)
+	if !ok {
+		http.Error(w, "Not a web+ap link", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), previewWait)
+	preview, complete := api.LookupPreview(ctx, target)
+	cancel()
+	cacheAge := oembedCacheAge
+	if !complete {
+		cacheAge = oembedRetryAge
+	}
+
+	var card bytes.Buffer
+	err = oembedCard.Execute(&card, struct {
+		Preview  *api.Preview
+		PageURL  string
+		SiteName string
+		Width    int
+		Height   int
+	}{preview, pageURL, s.config.SiteName, width, height})
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		HTML:         card.String(),
+		Width:        width,
+		Height:       height,
+		Title:        preview.Title,
+		AuthorName:   preview.Author,
+		ProviderName: s.config.SiteName,
+		ProviderURL:  origin + "/",
+		CacheAge:     cacheAge,
+	}
+	if preview.Author != "" {
+		resp.AuthorURL = origin + "/" + preview.Author
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(cacheAge))
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// oembedTarget parses the url an oEmbed consumer asked about. It has to be
+// one of our redirect pages on DOMAIN, or a bare web+ap:// URL. The page URL
+// is rebuilt on origin either way, as the response is publicly cacheable.
+//
+// Returns:
+//   - *api.Target: The target
+//   - string: The redirect page URL to link to
+//   - bool: Whether raw was a valid link
+func (s *Server) oembedTarget(raw, origin string) (*api.Target, string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, "", false
+	}
+
+	if strings.EqualFold(u.Scheme, "web+ap") {
+		target, err := api.ParseTarget(raw)
+		if err != nil {
+			return nil, "", false
+		}
+		return target, origin + "/" + strings.TrimPrefix(target.URI, "https://"), true
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, "", false
+	}
+	if !strings.EqualFold(u.Hostname(), s.config.Domain) {
+		return nil, "", false
+	}
+	target, err := targetFromURL(u)
+	if err != nil {
+		return nil, "", false
+	}
+	return target, origin + u.RequestURI(), true
+}
+
+// oembedSize returns the size to use for a maxwidth or maxheight parameter:
+// the default, shrunk to fit the parameter.
+//
+// Returns:
+//   - int: The size, in pixels
+//   - error: strconv.ErrSyntax if param isn't a positive number, or
+//     errOEmbedTooSmall if it's below floor
+func oembedSize(param string, def, floor int) (int, error) {
+	if param == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil || n <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	if n < floor {
+		return 0, errOEmbedTooSmall
+	}
+	return min(n, def), nil
+}
+
+// oembedSizeError writes the error response for an unusable size parameter.
+func oembedSizeError(w http.ResponseWriter, param string, err error) {
+	if errors.Is(err, errOEmbedTooSmall) {
+		http.Error(w, "The "+param+" parameter is "+err.Error(), http.StatusNotImplemented)
+		return
+	}
+	http.Error(w, "Invalid "+param+" parameter", http.StatusBadRequest)
+}
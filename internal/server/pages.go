@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"webap.to/internal/api"
+	"webap.to/internal/assets"
+	"webap.to/internal/metrics"
+	"webap.to/internal/ratelimit"
+)
+
+// previewWait is how long a link previewer is kept waiting for the target's
+// details before it gets the basics.
+const previewWait = 5 * time.Second
+
+// linkPreviewers are (lowercase) User-Agent fragments of the things that fetch
+// a page to unfurl a link to it: chat apps, social networks, embed services
+// and fediverse servers. "bot" covers most of them (Twitterbot, Slackbot,
+// Discordbot, TelegramBot, LinkedInBot, Matrix's Synapse, ...).
+var linkPreviewers = []string{
+	"bot", "facebookexternalhit", "whatsapp", "skypeuripreview", "embedly", "iframely",
+	"vkshare", "pinterest", "cardyb", "http.rb", "mastodon", "pleroma", "akkoma",
+	"misskey", "sharkey", "iceshrimp", "firefish", "gotosocial", "friendica",
+	"pixelfed", "lemmy", "piefed",
+}
+
+// isLinkPreviewer reports whether the request looks like it's from something
+// unfurling a link, rather than a person following it.
+func isLinkPreviewer(r *http.Request) bool {
+	ua := strings.ToLower(r.UserAgent())
+	for _, fragment := range linkPreviewers {
+		if strings.Contains(ua, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectPage is the data for the handle.html template. It mirrors the
+// redirect-handler component's state, so people without JavaScript see the
+// same thing.
+type redirectPage struct {
+	// Status is "redirecting", "manual", "needs-config" or "error".
+	Status string
+	// Target is the web+ap target's URI (e.g., "https://pixelfed.social/p/abc123").
+	Target       string
+	HomeInstance string
+	// Delay is the countdown in seconds, for "redirecting".
+	Delay       int
+	RedirectURL string
+
+	// Preview fills in the OpenGraph and Twitter card tags.
+	Preview *api.Preview
+	// SiteName is our name, from SITE_NAME.
+	SiteName string
+	// PageURL is this page's absolute URL.
+	PageURL string
+	// Logo is our logo's absolute URL, for previews without an image.
+	Logo string
+	// OEmbedURL is this page's oEmbed endpoint, for the discovery link.
+	OEmbedURL string
+}
+
+// pageTemplate is a page from the asset pipeline used as an html/template.
+// It's parsed once, except in dev mode where the file can change underneath.
+type pageTemplate struct {
+	static *assets.Pipeline
+	name   string
+	dev    bool
+	tmpl   *template.Template
+}
+
+// newPageTemplate parses the static page at name as a template.
+//
+// Parameters:
+//   - static: The asset pipeline to load the page from
+//   - name: The page's path (e.g., "/handle.html")
+//   - dev: Re-parse the page on every render
+//
+// Returns:
+//   - *pageTemplate: The template
+//   - error: If the page is missing or isn't a valid template
+func newPageTemplate(static *assets.Pipeline, name string, dev bool) (*pageTemplate, error) {
+	t := &pageTemplate{static: static, name: name, dev: dev}
+	tmpl, err := t.parse()
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl = tmpl
+	return t, nil
+}
+
+func (t *pageTemplate) parse() (*template.Template, error) {
+	a, ok := t.static.Lookup(t.name)
+	if !ok {
+		return nil, fmt.Errorf("page template %s not found", t.name)
+	}
+	return template.New(t.name).Parse(string(a.Content))
+}
+
+// render writes the page for data. The output depends on the visitor's
+// cookies, so it's never shared between them.
+func (t *pageTemplate) render(w http.ResponseWriter, r *http.Request, status int, data any) {
+	tmpl := t.tmpl
+	if t.dev {
+		var err error
+		if tmpl, err = t.parse(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to parse page template", "page", t.name, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render page", "page", t.name, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "private, no-cache")
+	h.Add("Vary", "Cookie")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// staticHandler serves the static file at the request path, or the 404 page.
+func (s *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.static.Lookup(r.URL.Path)
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	a.ServeHTTP(w, r)
+}
+
+// faviconHandler serves the logo for browsers and feed readers that ask for
+// /favicon.ico without looking at the page's icon link.
+func (s *Server) faviconHandler(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.static.Lookup("/images/webap.svg")
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	a.ServeHTTP(w, r)
+}
+
+// handlePageHandler serves handle.html itself, which has no target to
+// redirect to.
+func (s *Server) handlePageHandler(w http.ResponseWriter, r *http.Request) {
+	s.policies.handle.apply(w)
+	s.handlePage.render(w, r, http.StatusOK, redirectPage{Status: "error"})
+}
+
+// redirectHandler serves the redirect page for a web+ap target, taken from
+// the path (e.g., /pixelfed.social/p/abc123) or, for the protocol handler,
+// /authorize_interaction's uri parameter. Anything that isn't a valid target
+// gets the 404 page.
+//
+// The page is rendered with the preferences cookie, which storage.js keeps in
+// step with localStorage, so it redirects the same way with or without
+// JavaScript. The home instance is picked by the target's kind of content
+// (see api.Preferences.Route), going by the cached software only so the page
+// never waits on the target instance.
+//
+// The page also carries OpenGraph and Twitter card tags describing the target
+// (see api.Preview), so links to it unfurl into something useful.
+func (s *Server) redirectHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := targetFromURL(r.URL)
+	if err != nil {
+		s.notFound(w, r)
+		return
+	}
+	software := api.CachedSoftware(r.Context(), target.Host)
+	metrics.Redirects.WithLabelValues(api.SoftwareLabel(software)).Inc()
+
+	prefs, _ := api.RequestPreferences(r)
+	route := prefs.Route(target, software)
+	page := redirectPage{
+		Status:       "needs-config",
+		Target:       target.URI,
+		HomeInstance: route.Home,
+		Delay:        int(prefs.Delay),
+		RedirectURL:  route.RedirectURL,
+		SiteName:     s.config.SiteName,
+	}
+	origin := s.publicOrigin(r)
+	page.PageURL = origin + r.URL.RequestURI()
+	page.Logo = origin + s.static.Path("/images/webap.webp")
+	page.OEmbedURL = origin + "/oembed?format=json&url=" + url.QueryEscape(page.PageURL)
+
+	// Link previewers get the target's details, fetched if need be. People
+	// only get what's cached, so they're never kept waiting.
+	if isLinkPreviewer(r) {
+		ctx, cancel := context.WithTimeout(r.Context(), previewWait)
+		page.Preview, _ = api.LookupPreview(ctx, target)
+		cancel()
+	} else {
+		page.Preview = api.CachedPreview(target)
+	}
+	if page.HomeInstance != "" {
+		page.Status = "redirecting"
+		if prefs.Delay == api.DelayNever {
+			page.Status = "manual"
+		}
+	}
+
+	s.policies.handle.apply(w)
+	s.handlePage.render(w, r, http.StatusOK, page)
+}
+
+// targetFromURL returns the web+ap target of one of our redirect page URLs,
+// taken from the path (e.g., /pixelfed.social/p/abc123) or, for the protocol
+// handler, /authorize_interaction's uri parameter.
+func targetFromURL(u *url.URL) (*api.Target, error) {
+	if u.Path == "/authorize_interaction" {
+		return api.ParseTarget(u.Query().Get("uri"))
+	}
+	return api.ParseTarget(strings.TrimPrefix(u.EscapedPath(), "/"))
+}
+
+// secureRequest reports whether r reached the visitor's browser over HTTPS:
+// we serve TLS ourselves, or r came through a trusted proxy, which is taken to
+// terminate TLS unless it says otherwise with X-Forwarded-Proto. Anyone else's
+// X-Forwarded-Proto is ignored.
+func (s *Server) secureRequest(r *http.Request) bool {
+	if r.TLS != nil || s.config.TLSEnabled() {
+		return true
+	}
+	if !ratelimit.FromTrustedProxy(r, s.trustedProxies) {
+		return false
+	}
+	return !strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "http")
+}
+
+// publicOrigin returns the canonical origin of the site (e.g.,
+// "https://webap.to"), built from DOMAIN and how r reached us rather than its
+// Host header, so nothing we render or cache can be pointed elsewhere by a
+// forged header.
+func (s *Server) publicOrigin(r *http.Request) string {
+	cfg := s.config
+	switch {
+	case cfg.TLSEnabled():
+		return "https://" + hostWithPort(cfg.Domain, cfg.TLSPort, "443")
+	case ratelimit.FromTrustedProxy(r, s.trustedProxies):
+		// The proxy serves the standard port for its scheme.
+		if s.secureRequest(r) {
+			return "https://" + cfg.Domain
+		}
+		return "http://" + cfg.Domain
+	default:
+		return "http://" + hostWithPort(cfg.Domain, cfg.Port, "80")
+	}
+}
+
+// hostWithPort adds port to host unless it's the scheme's default.
+func hostWithPort(host, port, defaultPort string) string {
+	if port == "" || port == defaultPort {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// notFound serves the 404 page.
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.static.Lookup("/404.html")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	a.ServeStatus(w, r, http.StatusNotFound)
+}
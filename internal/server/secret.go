@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"webap.to/internal/config"
+)
+
+// minCookieSecret is the shortest saved secret we'll trust, in bytes.
+const minCookieSecret = 32
+
+// cookieSecret returns the key to sign the preferences cookie with:
+// COOKIE_SECRET if set, otherwise a random one kept in $DATA_DIR/cookie_secret
+// so cookies survive restarts. If that file can't be written, the key only
+// lasts until the process exits.
+//
+// Parameters:
+//   - cfg: Server configuration
+//
+// Returns:
+//   - []byte: The key
+func cookieSecret(cfg *config.Config) []byte {
+	if cfg.CookieSecret != "" {
+		return []byte(cfg.CookieSecret)
+	}
+
+	path := filepath.Join(cfg.DataDir, "cookie_secret")
+	if data, err := os.ReadFile(path); err == nil {
+		if secret := bytes.TrimSpace(data); len(secret) >= minCookieSecret {
+			return secret
+		}
+		slog.Warn("cookie secret file too short, generating a new one", "path", path)
+	}
+
+	raw := make([]byte, 32)
+	_, _ = rand.Read(raw)
+	secret := []byte(hex.EncodeToString(raw))
+	if err := os.WriteFile(path, append(secret, '\n'), 0o600); err != nil {
+		slog.Warn("failed to save cookie secret, preferences cookies won't survive a restart (set COOKIE_SECRET)",
+			"path", path, "error", err)
+	}
+	return secret
+}
@@ -4,36 +4,66 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
+	"net/netip"
+	"sync/atomic"
 	"time"
 
 	"webap.to/internal/api"
+	"webap.to/internal/assets"
 	"webap.to/internal/cache"
 	"webap.to/internal/config"
+	"webap.to/internal/metrics"
+	"webap.to/internal/ratelimit"
+	"webap.to/internal/tracing"
 )
 
 // Server is the main HTTP server for the WebAP.to service.
 //
-// Embeds http.Server and adds cache management. Routes:
+// Embeds http.Server and adds the cache, static assets and per-route security
+// policies. Routes (anything else gets a 404 page):
 //   - GET /api/software?instance={domain} - Returns instance software info
+//   - POST /api/software/batch - Returns software info for many instances at once
+//   - GET /api/instance?domain={domain} - Returns normalised instance metadata
+//   - GET /api/route?uri={target} - Picks the home instance for a target by its kind of content
+//   - GET, PUT /api/preferences - Reads or replaces the preferences cookie
+//   - GET /healthz - Liveness probe
+//   - GET /readyz - Readiness probe, including a cache backend ping
+//   - GET /metrics - Prometheus metrics (unless METRICS_ADDR moves it to its own listener)
 //   - GET / - Landing page
-//   - GET /css/*, /js/*, /images/*, /components/*, /dist/* - Static assets
+//   - GET /css/*, /js/*, /images/*, /components/*, /vendor/*, /dist/* - Static assets, plain or hashed
 //   - GET /manifest.json, /sw.js, /handle.html, /set-home.html - PWA files
+//   - GET /robots.txt, /favicon.ico - For crawlers and browsers that go looking
+//   - GET /oembed?url={url} - oEmbed provider for redirect page links
 //   - GET /authorize_interaction?uri={uri} - Protocol handler endpoint
-//   - GET /* (anything else) - Serves handle.html for client-side routing
+//   - GET /{host}/{path...}, /@{user}@{host} - Redirect page, if the path is a valid web+ap target
+//   - POST /set-home - Sets the home instance in the preferences cookie, for the redirect page without JavaScript
 type Server struct {
 	http.Server
-	cache  cache.Cache
-	config *config.Config
+	cache    cache.Cache
+	config   *config.Config
+	metrics  *http.Server
+	redirect *http.Server
+	draining atomic.Bool
+
+	// trustedProxies are the proxies whose X-Forwarded-* headers we believe.
+	trustedProxies []netip.Prefix
+
+	static     *assets.Pipeline
+	handlePage *pageTemplate
+	policies   securityPolicies
 }
 
 // New creates a new server with the given configuration and static file system.
 //
 // Initializes the cache, registers API routes, and sets up static file serving.
-// Cache initialization failures are logged but don't prevent server startup.
+// Cache initialization failures are logged but don't prevent server startup,
+// unless cfg.CacheRequired is set.
 //
 // Parameters:
 //   - cfg: Server configuration (port, database URL, etc.)
@@ -41,56 +71,214 @@ type Server struct {
 //
 // Returns:
 //   - *Server: Configured server ready to start with ListenAndServe()
-func New(cfg *config.Config, staticFS fs.FS) *Server {
+//   - error: Invalid TRUSTED_PROXIES or TLS settings, unreadable static assets, or a cache initialization error if cfg.CacheRequired is set
+func New(cfg *config.Config, staticFS fs.FS) (*Server, error) {
+	trustedProxies, err := ratelimit.ParseProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+
 	mux := http.NewServeMux()
 
-	fileServer := http.FileServer(http.FS(staticFS))
+	// Static files are served from memory with content-hashed URLs, unless
+	// STATIC_DIR points at a directory being worked on.
+	var assetOpts []assets.Option
+	if cfg.FrontendCDN {
+		assetOpts = append(assetOpts, assets.WithCDN())
+	}
+	var static *assets.Pipeline
+	if cfg.StaticDir != "" {
+		static, err = assets.NewDev(staticFS, assetOpts...)
+	} else {
+		static, err = assets.New(staticFS, assetOpts...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load static assets: %w", err)
+	}
 
 	instanceCache, err := cache.New(cfg.DatabaseURL)
-	if err != nil {
-		log.Printf("Warning: Failed to initialize cache: %v", err)
-	} else {
+	switch {
+	case err != nil && cfg.CacheRequired:
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	case err != nil:
+		slog.Warn("failed to initialize cache, running uncached", "error", err)
+	default:
 		api.SetCache(instanceCache)
 	}
+	api.SetOutboundLimits(cfg.OutboundPerHost, cfg.OutboundConcurrency)
+	api.SetPreferencesKey(cookieSecret(cfg))
+	api.SetPublicDomain(cfg.Domain)
 
-	mux.HandleFunc("/api/software", api.SoftwareHandler)
+	handlePage, err := newPageTemplate(static, "/handle.html", cfg.StaticDir != "")
+	if err != nil {
+		return nil, err
+	}
 
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		path := r.URL.Path
+	s := &Server{
+		cache:          instanceCache,
+		config:         cfg,
+		static:         static,
+		handlePage:     handlePage,
+		trustedProxies: trustedProxies,
+	}
+	api.SetSecureRequest(s.secureRequest)
 
-		if path == "/" {
-			fileServer.ServeHTTP(w, r)
-			return
+	fetches := ratelimit.NewLimiter(cfg.RateLimitFetches)
+	limit := ratelimit.Middleware(ratelimit.NewLimiter(cfg.RateLimitLookups), fetches, trustedProxies)
+	// Redirect pages aren't limited themselves, but link previews for them
+	// share the API's fetch budget.
+	previewLimit := ratelimit.Middleware(nil, fetches, trustedProxies)
+
+	cors := newCORSPolicy(cfg)
+	policies := newSecurityPolicies(cfg, static)
+	s.policies = policies
+
+	software := policies.api.wrap(gzipJSON(cors.handler("GET",
+		tracing.Handler("/api/software", limit(http.HandlerFunc(api.SoftwareHandler))))))
+	batch := policies.api.wrap(gzipJSON(cors.handler("POST",
+		tracing.Handler("/api/software/batch", limit(http.HandlerFunc(api.SoftwareBatchHandler))))))
+	instance := policies.api.wrap(gzipJSON(cors.handler("GET",
+		tracing.Handler("/api/instance", limit(http.HandlerFunc(api.InstanceHandler))))))
+	route := policies.api.wrap(gzipJSON(cors.handler("GET",
+		tracing.Handler("/api/route", limit(http.HandlerFunc(api.RouteHandler))))))
+	preferences := policies.api.wrap(gzipJSON(cors.handler("GET, PUT",
+		tracing.Handler("/api/preferences", http.HandlerFunc(api.PreferencesHandler)))))
+
+	// API routes also take OPTIONS, for CORS preflights.
+	mux.Handle("GET /api/software", software)
+	mux.Handle("OPTIONS /api/software", software)
+	mux.Handle("POST /api/software/batch", batch)
+	mux.Handle("OPTIONS /api/software/batch", batch)
+	mux.Handle("GET /api/instance", instance)
+	mux.Handle("OPTIONS /api/instance", instance)
+	mux.Handle("GET /api/route", route)
+	mux.Handle("OPTIONS /api/route", route)
+	mux.Handle("GET /api/preferences", preferences)
+	mux.Handle("PUT /api/preferences", preferences)
+	mux.Handle("OPTIONS /api/preferences", preferences)
+	mux.Handle("GET /api/", policies.api.wrap(http.NotFoundHandler()))
+
+	mux.HandleFunc("GET /healthz", s.healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+
+	if cfg.MetricsAddr == "" {
+		mux.Handle("GET /metrics", metrics.Handler(cfg.MetricsToken))
+	} else {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", metrics.Handler(cfg.MetricsToken))
+		s.metrics = &http.Server{
+			Addr:              cfg.MetricsAddr,
+			Handler:           adminMux,
+			ReadHeaderTimeout: 10 * time.Second,
 		}
+	}
+
+	// Static files. Anything not listed here, or not a file, gets the 404 page.
+	mux.HandleFunc("GET /{$}", s.staticHandler)
+	for _, dir := range []string{"/css/", "/js/", "/images/", "/components/", "/vendor/", "/dist/"} {
+		mux.HandleFunc("GET "+dir, s.staticHandler)
+	}
+	for _, file := range []string{"/index.html", "/set-home.html", "/manifest.json", "/sw.js", "/robots.txt"} {
+		mux.HandleFunc("GET "+file, s.staticHandler)
+	}
+	mux.HandleFunc("GET /favicon.ico", s.faviconHandler)
+	mux.HandleFunc("GET /handle.html", s.handlePageHandler)
 
-		if strings.HasPrefix(path, "/css/") ||
-			strings.HasPrefix(path, "/js/") ||
-			strings.HasPrefix(path, "/images/") ||
-			strings.HasPrefix(path, "/components/") ||
-			strings.HasPrefix(path, "/dist/") ||
-			path == "/manifest.json" ||
-			path == "/sw.js" ||
-			path == "/handle.html" ||
-			path == "/set-home.html" {
-			fileServer.ServeHTTP(w, r)
-			return
+	oembed := policies.api.wrap(gzipJSON(cors.handler("GET",
+		tracing.Handler("/oembed", limit(http.HandlerFunc(s.oembedHandler))))))
+	mux.Handle("GET /oembed", oembed)
+	mux.Handle("OPTIONS /oembed", oembed)
+
+	// Redirects. Only paths that parse as web+ap targets get the redirect
+	// page, so typos and scanner probes get a real 404.
+	redirect := previewLimit(http.HandlerFunc(s.redirectHandler))
+	mux.Handle("GET /authorize_interaction", redirect)
+	mux.Handle("GET /{target...}", redirect)
+	mux.HandleFunc("POST /set-home", api.SetHomeHandler)
+
+	s.Server = http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           requestLogger(metrics.Middleware(securityHeaders(cfg.HSTSMaxAge, policies.page, mux))),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	if cfg.TLSEnabled() {
+		if err := s.setupTLS(); err != nil {
+			_ = s.Close()
+			return nil, err
 		}
+	}
 
-		// All other paths (including /authorize_interaction) serve handle.html
-		// which handles protocol redirects client-side
-		r.URL.Path = "/handle.html"
-		fileServer.ServeHTTP(w, r)
-	})
+	return s, nil
+}
 
-	return &Server{
-		Server: http.Server{
-			Addr:              ":" + cfg.Port,
-			Handler:           mux,
-			ReadHeaderTimeout: 10 * time.Second,
-		},
-		cache:  instanceCache,
-		config: cfg,
+// ListenAndServe starts the metrics listener (if configured on its own
+// address) and, with TLS enabled, the plain HTTP redirect listener in the
+// background, then serves the main site (over HTTPS if TLS is enabled).
+//
+// Returns:
+//   - error: As http.Server.ListenAndServe, always non-nil
+func (s *Server) ListenAndServe() error {
+	if s.redirect != nil {
+		go func() {
+			if err := s.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn("HTTP redirect server error", "error", err)
+			}
+		}()
+	}
+	if s.metrics != nil {
+		go func() {
+			slog.Info("metrics listening", "url", "http://"+s.metrics.Addr+"/metrics")
+			if err := s.metrics.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn("metrics server error", "error", err)
+			}
+		}()
+	}
+	if s.Server.TLSConfig != nil {
+		return s.Server.ListenAndServeTLS("", "")
+	}
+	return s.Server.ListenAndServe()
+}
+
+// Drain marks the server as shutting down, so /readyz fails and load
+// balancers stop sending it traffic, while it carries on serving.
+func (s *Server) Drain() {
+	s.draining.Store(true)
+}
+
+// Shutdown gracefully shuts down the server and releases resources.
+//
+// Stops accepting new connections and waits for in-flight requests to finish.
+// If ctx expires first, the remaining connections are closed. Then it stops the
+// metrics and redirect listeners and closes the cache connection. The api
+// package keeps its reference to the cache, so handlers still running see a
+// closed cache (and treat it as a miss) rather than racing a reset.
+//
+// Parameters:
+//   - ctx: Bounds how long to wait for in-flight requests to drain
+//
+// Returns:
+//   - error: Any error from draining the HTTP server or closing the cache
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	err := s.Server.Shutdown(ctx)
+	if err != nil {
+		err = errors.Join(err, s.Server.Close())
+	}
+
+	if s.metrics != nil {
+		err = errors.Join(err, s.metrics.Shutdown(ctx))
 	}
+	if s.redirect != nil {
+		err = errors.Join(err, s.redirect.Shutdown(ctx))
+	}
+
+	if s.cache != nil {
+		err = errors.Join(err, s.cache.Close())
+	}
+
+	return err
 }
 
 // Close shuts down the server and releases resources.
@@ -103,5 +291,11 @@ func (s *Server) Close() error {
 	if s.cache != nil {
 		_ = s.cache.Close()
 	}
+	if s.metrics != nil {
+		_ = s.metrics.Close()
+	}
+	if s.redirect != nil {
+		_ = s.redirect.Close()
+	}
 	return s.Server.Close()
 }
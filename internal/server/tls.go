@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certCheckInterval is how often manually configured certificate files are
+// checked for changes, so renewed certificates get picked up without a restart.
+const certCheckInterval = time.Minute
+
+// setupTLS switches the server to HTTPS on cfg.TLSPort, with certificates
+// either from ACME (autocert) or from files. The plain HTTP port then only
+// answers ACME challenges and health checks, and redirects everything else.
+//
+// Returns:
+//   - error: If the TLS configuration is inconsistent or the certificate can't be loaded
+func (s *Server) setupTLS() error {
+	cfg := s.config
+
+	switch {
+	case cfg.TLSAutocert && (cfg.TLSCertFile != "" || cfg.TLSKeyFile != ""):
+		return errors.New("TLS_AUTOCERT can't be combined with TLS_CERT_FILE/TLS_KEY_FILE")
+	case !cfg.TLSAutocert && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == ""):
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	plainMux := http.NewServeMux()
+	plainMux.HandleFunc("/healthz", s.healthzHandler)
+	plainMux.HandleFunc("/readyz", s.readyzHandler)
+	plainMux.Handle("/", redirectToHTTPS(cfg.Domain, cfg.TLSPort))
+	var plain http.Handler = plainMux
+
+	if cfg.TLSAutocert {
+		if cfg.Domain == "" || cfg.Domain == "localhost" || net.ParseIP(cfg.Domain) != nil {
+			return fmt.Errorf("TLS_AUTOCERT needs DOMAIN set to a public hostname, not %q", cfg.Domain)
+		}
+
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(filepath.Join(cfg.DataDir, "autocert")),
+			HostPolicy: autocert.HostWhitelist(cfg.Domain),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectory != "" || cfg.ACMECARoot != "" {
+			m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+			if cfg.ACMECARoot != "" {
+				client, err := acmeHTTPClient(cfg.ACMECARoot)
+				if err != nil {
+					return err
+				}
+				m.Client.HTTPClient = client
+			}
+		}
+
+		s.Server.TLSConfig = m.TLSConfig()
+		plain = m.HTTPHandler(plainMux)
+	} else {
+		certs := &certReloader{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+		if _, err := certs.GetCertificate(nil); err != nil {
+			return err
+		}
+		s.Server.TLSConfig = &tls.Config{
+			GetCertificate: certs.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+	}
+	s.Server.TLSConfig.MinVersion = tls.VersionTLS12
+	s.Server.Addr = ":" + cfg.TLSPort
+
+	s.redirect = &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           requestLogger(plain),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          s.Server.ErrorLog,
+	}
+
+	return nil
+}
+
+// acmeHTTPClient returns an HTTP client for the ACME directory that trusts the
+// root CAs in caFile as well as the system's, for CAs like Pebble whose
+// directory has a self-signed certificate.
+//
+// Returns:
+//   - *http.Client: The client
+//   - error: If caFile can't be read or has no certificates
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME_CA_ROOT: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("ACME_CA_ROOT %s has no PEM certificates", caFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+// redirectToHTTPS permanently redirects GET and HEAD requests to the same path
+// on https://{domain}. The configured domain is used rather than the Host
+// header, so we never redirect anywhere we don't serve.
+func redirectToHTTPS(domain, port string) http.Handler {
+	host := domain
+	if port != "443" {
+		host = net.JoinHostPort(domain, port)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// certReloader serves a certificate from files, reloading it when the files
+// change (e.g. after certbot renews it).
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.cert != nil && now.Sub(c.checked) < certCheckInterval {
+		return c.cert, nil
+	}
+	c.checked = now
+
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.cert != nil {
+			slog.Warn("failed to check TLS certificate, keeping the current one", "file", c.certFile, "error", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if c.cert != nil && info.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		if c.cert != nil {
+			slog.Warn("failed to reload TLS certificate, keeping the current one", "file", c.certFile, "error", err)
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	if c.cert != nil {
+		slog.Info("reloaded TLS certificate", "file", c.certFile)
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return c.cert, nil
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package tracing sets up OpenTelemetry tracing, exported over OTLP/HTTP.
+//
+// Until Setup is called with an endpoint the global tracer provider is the
+// OpenTelemetry no-op one, so instrumented code costs next to nothing when
+// tracing isn't configured.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+// ServiceName is the service.name resource attribute spans are exported with.
+const ServiceName = "webap.to"
+
+// Setup installs the global tracer provider and propagator.
+//
+// Parameters:
+//   - ctx: Context for creating the exporter
+//   - endpoint: Base URL of an OTLP/HTTP collector (e.g., "http://localhost:4318").
+//     Spans are sent to {endpoint}/v1/traces unless it already has a path.
+//     Empty disables tracing.
+//
+// Returns:
+//   - func(context.Context) error: Flushes pending spans and stops the exporter
+//   - error: If the endpoint is invalid or the exporter couldn't be created
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return provider.Shutdown, nil
+}
+
+// Handler wraps an HTTP handler so each request gets a server span, continuing
+// any trace propagated by the caller.
+//
+// Parameters:
+//   - route: The route pattern, used as the span name (e.g., "/api/software")
+//   - h: The handler to wrap
+//
+// Returns:
+//   - http.Handler: The instrumented handler
+func Handler(route string, h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, route)
+}
+
+// Transport wraps an outbound transport so each request gets a client span
+// and carries the trace context.
+//
+// Parameters:
+//   - rt: The transport to wrap
+//
+// Returns:
+//   - http.RoundTripper: The instrumented transport
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt)
+}
@@ -4,27 +4,105 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/atikayda/cachedfs"
 	"github.com/joho/godotenv"
 
 	"webap.to/internal/config"
+	"webap.to/internal/logging"
 	"webap.to/internal/server"
+	"webap.to/internal/tracing"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	_ = godotenv.Load()
 
 	cfg := config.Load()
+	slog.SetDefault(logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel))
+
+	staticFS, closeStatic, err := openStatic(cfg.StaticDir)
+	if err != nil {
+		slog.Error("failed to initialize static file cache", "dir", cfg.StaticDir, "error", err)
+		return 1
+	}
+	defer closeStatic()
 
-	staticFS, err := cachedfs.New(cfg.StaticDir, cachedfs.WithFSNotify())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.OTLPEndpoint)
 	if err != nil {
-		log.Fatalf("Failed to initialize static file cache: %v", err)
+		slog.Error("failed to initialize tracing", "error", err)
+		return 1
+	}
+	defer func() {
+		// Flush whatever spans are still buffered, but don't hang about if the
+		// collector has gone away.
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+	}()
+
+	srv, err := server.New(cfg, staticFS)
+	if err != nil {
+		slog.Error("failed to initialize server", "error", err)
+		return 1
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		url := "http://" + cfg.Domain + ":" + cfg.Port
+		if cfg.TLSEnabled() {
+			url = "https://" + cfg.Domain + ":" + cfg.TLSPort
+		}
+		slog.Info("starting", "site", cfg.SiteName, "url", url)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		// Never got as far as a signal, so the listener itself failed.
+		slog.Error("server error", "error", err)
+		_ = srv.Close()
+		return 1
+	case <-ctx.Done():
+	}
+
+	// A second signal while draining kills the process the usual way.
+	stop()
+
+	srv.Drain()
+	if cfg.ShutdownDelay > 0 {
+		slog.Info("shutting down, waiting for load balancers", "delay", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+
+	slog.Info("shutting down, draining connections", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown error", "error", err)
+		return 1
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server error", "error", err)
+		return 1
 	}
-	defer func() { _ = staticFS.Close() }()
 
-	srv := server.New(cfg, staticFS)
-	log.Printf("%s starting on http://%s:%s", cfg.SiteName, cfg.Domain, cfg.Port)
-	log.Fatal(srv.ListenAndServe()) //nolint:gocritic // intentional: cleanup not needed on fatal server error
+	slog.Info("stopped", "site", cfg.SiteName)
+	return 0
 }
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+
+	"github.com/atikayda/cachedfs"
+)
+
+// embeddedStatic is the frontend, baked into the binary at build time.
+//
+//go:embed static
+var embeddedStatic embed.FS
+
+// openStatic returns the filesystem to serve the frontend from.
+//
+// By default that's the copy embedded in the binary. Setting STATIC_DIR serves
+// from disk instead, watching for changes, which is handy while hacking on the
+// frontend.
+//
+// Parameters:
+//   - dir: The static directory override, "" for the embedded files
+//
+// Returns:
+//   - fs.FS: The static files, rooted at the static directory
+//   - func(): Releases the filesystem's resources
+//   - error: If the directory couldn't be opened
+func openStatic(dir string) (fs.FS, func(), error) {
+	if dir == "" {
+		sub, err := fs.Sub(embeddedStatic, "static")
+		return sub, func() {}, err
+	}
+
+	diskFS, err := cachedfs.New(dir, cachedfs.WithFSNotify())
+	if err != nil {
+		return nil, nil, err
+	}
+	return diskFS, func() { _ = diskFS.Close() }, nil
+}
@@ -1,8 +1,8 @@
 // SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
 // SPDX-License-Identifier: AGPL-3.0-only
 
-import { LitElement, html, css } from 'https://cdn.jsdelivr.net/npm/lit@3/+esm';
-import { unsafeHTML } from 'https://cdn.jsdelivr.net/npm/lit@3/directives/unsafe-html.js/+esm';
+import { LitElement, html, css } from 'lit';
+import { unsafeHTML } from 'lit/directives/unsafe-html.js';
 
 export class CodeSnippet extends LitElement {
   static properties = {
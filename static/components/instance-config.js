@@ -1,8 +1,8 @@
 // SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
 // SPDX-License-Identifier: AGPL-3.0-only
 
-import { LitElement, html, css } from 'https://cdn.jsdelivr.net/npm/lit@3/+esm';
-import { getHomeInstance, setHomeInstance, clearHomeInstance, getConfig, setConfig, getSoftwareInfo, setHomeSoftware, setCategorySoftware, normalizeDomain } from '/js/storage.js';
+import { LitElement, html, css } from 'lit';
+import { getHomeInstance, setHomeInstance, clearHomeInstance, getConfig, setConfig, syncPreferences, getSoftwareInfo, setHomeSoftware, setCategorySoftware, normalizeDomain } from '/js/storage.js';
 import { registerHandler, supportsProtocolHandler } from '/js/protocol.js';
 
 const GENERAL_PURPOSE_SOFTWARE = [
@@ -376,6 +376,14 @@ export class InstanceConfig extends LitElement {
 
   connectedCallback() {
     super.connectedCallback();
+    this.loadConfig();
+    // Pick up a home instance set without JavaScript
+    syncPreferences().then(() => {
+      if (this.status !== 'configured') this.loadConfig();
+    });
+  }
+
+  loadConfig() {
     this.config = getConfig();
     const saved = getHomeInstance();
     if (saved) {
@@ -1,9 +1,9 @@
 // SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
 // SPDX-License-Identifier: AGPL-3.0-only
 
-import { LitElement, html, css } from 'https://cdn.jsdelivr.net/npm/lit@3/+esm';
-import { getHomeInstance, setPendingRedirect, getConfig } from '/js/storage.js';
-import { parseWebApUrl, buildAuthorizeUrl } from '/js/protocol.js';
+import { LitElement, html, css } from 'lit';
+import { getHomeInstance, setPendingRedirect, getConfig, syncPreferences } from '/js/storage.js';
+import { parseWebApUrl, buildAuthorizeUrl, fetchRoute } from '/js/protocol.js';
 
 export class RedirectHandler extends LitElement {
   static properties = {
@@ -163,7 +163,7 @@ export class RedirectHandler extends LitElement {
     this.parseAndRedirect();
   }
 
-  parseAndRedirect() {
+  async parseAndRedirect() {
     const url = new URL(window.location.href);
 
     let target;
@@ -179,9 +179,14 @@ export class RedirectHandler extends LitElement {
     }
 
     this.target = target;
+    await syncPreferences();
     this.homeInstance = getHomeInstance();
 
     if (this.homeInstance) {
+      const route = await fetchRoute(this.target, getConfig());
+      if (route?.home) {
+        this.homeInstance = route.home;
+      }
       this.initiateRedirect();
     } else {
       setPendingRedirect(this.target);
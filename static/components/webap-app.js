@@ -1,7 +1,7 @@
 // SPDX-FileCopyrightText: 2025 Atikayda Pty Ltd
 // SPDX-License-Identifier: AGPL-3.0-only
 
-import { LitElement, html, css } from 'https://cdn.jsdelivr.net/npm/lit@3/+esm';
+import { LitElement, html, css } from 'lit';
 import './instance-config.js';
 import './redirect-handler.js';
 import './code-snippet.js';
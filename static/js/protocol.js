@@ -30,3 +30,22 @@ export function buildAuthorizeUrl(homeInstance, targetUri) {
   const fullUri = targetUri.startsWith('http') ? targetUri : `https://${targetUri}`;
   return `https://${homeInstance}/authorize_interaction?uri=${encodeURIComponent(fullUri)}`;
 }
+
+const ROUTE_TIMEOUT_MS = 3000;
+
+// Asks the server which of the configured homes suits the target's kind of
+// content (Pixelfed posts to the photo home and so on). Gives up quickly, and
+// returns null, so a slow instance never holds up the redirect.
+export async function fetchRoute(targetUri, config) {
+  const params = new URLSearchParams({ uri: targetUri });
+  for (const category of ['social', 'community', 'photo', 'video', 'music', 'blog']) {
+    if (config[category]) params.set(category, config[category]);
+  }
+  try {
+    const response = await fetch(`/api/route?${params}`, { signal: AbortSignal.timeout(ROUTE_TIMEOUT_MS) });
+    if (!response.ok) return null;
+    return await response.json();
+  } catch {
+    return null;
+  }
+}
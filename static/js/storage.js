@@ -6,6 +6,10 @@ const CONFIG_KEY = 'webap_config';
 const SOFTWARE_KEY = 'webap_software';
 const PENDING_REDIRECT_KEY = 'webap_pending_redirect';
 
+// The server keeps a copy of the config in a signed cookie, so it can redirect
+// the same way before (or without) any JavaScript running.
+const PREFERENCES_URL = '/api/preferences';
+
 const DEFAULT_CONFIG = {
   social: '',
   community: '',
@@ -49,12 +53,74 @@ export function setConfig(config) {
   try {
     localStorage.setItem(CONFIG_KEY, JSON.stringify(config));
     localStorage.removeItem(STORAGE_KEY);
+    pushPreferences(config);
     return true;
   } catch {
     return false;
   }
 }
 
+function toPreferences(config) {
+  return {
+    social: config.social || '',
+    community: config.community || '',
+    photo: config.photo || '',
+    video: config.video || '',
+    music: config.music || '',
+    blog: config.blog || '',
+    delay: config.delay ?? DEFAULT_CONFIG.delay,
+  };
+}
+
+async function pushPreferences(config) {
+  try {
+    const response = await fetch(PREFERENCES_URL, {
+      method: 'PUT',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(toPreferences(config)),
+      keepalive: true,
+    });
+    return response.ok;
+  } catch {
+    return false;
+  }
+}
+
+// Reconciles localStorage with the server's cookie: an empty localStorage
+// picks up the cookie (e.g. after setting a home instance without
+// JavaScript), otherwise localStorage wins and the cookie is updated to match.
+export async function syncPreferences() {
+  let hasLocal;
+  try {
+    hasLocal = localStorage.getItem(CONFIG_KEY) !== null || localStorage.getItem(STORAGE_KEY) !== null;
+  } catch {
+    return;
+  }
+
+  let server;
+  try {
+    const response = await fetch(PREFERENCES_URL);
+    if (!response.ok) return;
+    server = toPreferences(await response.json());
+  } catch {
+    return;
+  }
+
+  if (!hasLocal) {
+    if (server.social || server.community || server.photo || server.video || server.music || server.blog) {
+      try {
+        localStorage.setItem(CONFIG_KEY, JSON.stringify(server));
+      } catch {}
+    }
+    return;
+  }
+
+  const local = toPreferences(getConfig());
+  if (JSON.stringify(local) !== JSON.stringify(server)) {
+    await pushPreferences(local);
+  }
+}
+
 export function getHomeInstance() {
   const config = getConfig();
   return config.social || config.community || config.photo || config.video || config.music || config.blog || null;
@@ -90,6 +156,7 @@ export function clearHomeInstance() {
     localStorage.removeItem(STORAGE_KEY);
     localStorage.removeItem(CONFIG_KEY);
     localStorage.removeItem(SOFTWARE_KEY);
+    pushPreferences(DEFAULT_CONFIG);
     return true;
   } catch {
     return false;